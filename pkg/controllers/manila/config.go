@@ -0,0 +1,230 @@
+package manila
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openshift/csi-driver-manila-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ConfigMap in the operator namespace with operator configuration.
+	operatorConfigMapName = "manila-csi-driver-operator-config"
+	// Key in the ConfigMap with the configuration.
+	operatorConfigKey = "config.yaml"
+)
+
+// operatorConfig is configuration of the operator provided by admins in
+// operatorConfigMapName ConfigMap. Example:
+//
+//	setDefaultStorageClass: true
+//	retainVolumeSnapshotClasses: true
+//	shareNetworkDiscovery: Auto
+//	uninstallAfterManilaGone: 168h
+//	quotaWarningThreshold: 80
+//	deleteOrphanedSharesAfter: 720h
+//	additionalNFSShareClients:
+//	- 192.168.100.0/24
+//	storageClasses:
+//	  gold:
+//	    reclaimPolicy: Retain
+//	    volumeBindingMode: WaitForFirstConsumer
+//	    mountOptions:
+//	    - vers=4.1
+//	    - nconnect=4
+//	    parameters:
+//	      nfs-shareClient: 10.0.0.0/16
+//	    labels:
+//	      tier: gold
+type operatorConfig struct {
+	// SetDefaultStorageClass marks StorageClass of the Manila default share
+	// type as the cluster default, unless there already is another default
+	// StorageClass.
+	SetDefaultStorageClass bool `json:"setDefaultStorageClass,omitempty"`
+	// RetainVolumeSnapshotClasses creates a VolumeSnapshotClass with Retain
+	// deletionPolicy for each share type that supports snapshots, in addition
+	// to the default one with Delete deletionPolicy.
+	RetainVolumeSnapshotClasses bool `json:"retainVolumeSnapshotClasses,omitempty"`
+	// ShareNetworkDiscovery set to "Auto" finds or creates a share network
+	// on the Neutron subnet of the cluster nodes and uses it in StorageClasses
+	// of share types with driver_handles_share_servers=True. Such share
+	// types are skipped otherwise.
+	ShareNetworkDiscovery string `json:"shareNetworkDiscovery,omitempty"`
+	// UninstallAfterManilaGone removes the CSI driver, its StorageClasses
+	// and VolumeSnapshotClasses when Manila endpoint has been missing in the
+	// cloud for this long. The driver is installed again when Manila comes
+	// back. Nothing is removed when it's not set.
+	UninstallAfterManilaGone *metav1.Duration `json:"uninstallAfterManilaGone,omitempty"`
+	// QuotaWarningThreshold is usage of a Manila quota, in percent, at which
+	// the quota is reported in a condition. 90 when not set.
+	QuotaWarningThreshold *int `json:"quotaWarningThreshold,omitempty"`
+	// DeleteOrphanedSharesAfter deletes Manila shares tagged with the cluster
+	// infrastructureName that have no PV and are older than the duration.
+	// Orphaned shares are only reported when not set.
+	DeleteOrphanedSharesAfter *metav1.Duration `json:"deleteOrphanedSharesAfter,omitempty"`
+	// NFSShareClients are IP addresses or CIDRs allowed to access NFS
+	// shares, instead of the machine network of the cluster.
+	NFSShareClients []string `json:"nfsShareClients,omitempty"`
+	// AdditionalNFSShareClients are IP addresses or CIDRs allowed to access
+	// NFS shares in addition to the machine network or NFSShareClients.
+	AdditionalNFSShareClients []string `json:"additionalNFSShareClients,omitempty"`
+	// StorageClasses are overrides of generated StorageClasses, keyed by
+	// Manila share type name.
+	StorageClasses map[string]storageClassOverride `json:"storageClasses,omitempty"`
+}
+
+// storageClassOverride is merged into a StorageClass generated for a share type.
+type storageClassOverride struct {
+	ReclaimPolicy     *corev1.PersistentVolumeReclaimPolicy `json:"reclaimPolicy,omitempty"`
+	VolumeBindingMode *storagev1.VolumeBindingMode          `json:"volumeBindingMode,omitempty"`
+	MountOptions      []string                              `json:"mountOptions,omitempty"`
+	// Parameters are added to the StorageClass parameters. Parameters set by
+	// the operator (share type, secrets and share metadata) can't be overridden.
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// Labels are added to the StorageClass labels.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// getOperatorConfig returns the operator configuration. Missing ConfigMap
+// is an empty configuration. The returned configuration is never nil, it's
+// empty when the ConfigMap can't be parsed.
+func (c *ManilaController) getOperatorConfig() (*operatorConfig, error) {
+	cm, err := c.configMapLister.ConfigMaps(util.OperatorNamespace).Get(operatorConfigMapName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return &operatorConfig{}, nil
+		}
+		return &operatorConfig{}, err
+	}
+	cfg, err := parseOperatorConfig(cm.Data[operatorConfigKey])
+	if err != nil {
+		return &operatorConfig{}, err
+	}
+	return cfg, nil
+}
+
+func parseOperatorConfig(data string) (*operatorConfig, error) {
+	cfg := &operatorConfig{}
+	if err := yaml.UnmarshalStrict([]byte(data), cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse key %s of ConfigMap %s/%s: %w", operatorConfigKey, util.OperatorNamespace, operatorConfigMapName, err)
+	}
+	switch cfg.ShareNetworkDiscovery {
+	case "", shareNetworkDiscoveryAuto, shareNetworkDiscoveryDisabled:
+	default:
+		return nil, fmt.Errorf("invalid shareNetworkDiscovery %q in ConfigMap %s/%s: must be %s or %s", cfg.ShareNetworkDiscovery, util.OperatorNamespace, operatorConfigMapName, shareNetworkDiscoveryAuto, shareNetworkDiscoveryDisabled)
+	}
+	for _, client := range append(slices.Clone(cfg.NFSShareClients), cfg.AdditionalNFSShareClients...) {
+		if err := validateShareClient(client); err != nil {
+			return nil, fmt.Errorf("invalid NFS share client in ConfigMap %s/%s: %w", util.OperatorNamespace, operatorConfigMapName, err)
+		}
+	}
+	if cfg.DeleteOrphanedSharesAfter != nil && cfg.DeleteOrphanedSharesAfter.Duration < orphanGracePeriod {
+		return nil, fmt.Errorf("invalid deleteOrphanedSharesAfter %s in ConfigMap %s/%s: must be at least %s", cfg.DeleteOrphanedSharesAfter.Duration, util.OperatorNamespace, operatorConfigMapName, orphanGracePeriod)
+	}
+	if cfg.UninstallAfterManilaGone != nil && cfg.UninstallAfterManilaGone.Duration <= 0 {
+		return nil, fmt.Errorf("invalid uninstallAfterManilaGone %s in ConfigMap %s/%s: must be positive", cfg.UninstallAfterManilaGone.Duration, util.OperatorNamespace, operatorConfigMapName)
+	}
+	if cfg.QuotaWarningThreshold != nil && (*cfg.QuotaWarningThreshold <= 0 || *cfg.QuotaWarningThreshold > 100) {
+		return nil, fmt.Errorf("invalid quotaWarningThreshold %d in ConfigMap %s/%s: must be between 1 and 100", *cfg.QuotaWarningThreshold, util.OperatorNamespace, operatorConfigMapName)
+	}
+	return cfg, nil
+}
+
+// getDeleteOrphanedSharesAfter returns DeleteOrphanedSharesAfter, 0 when
+// orphaned shares should not be deleted.
+func (cfg *operatorConfig) getDeleteOrphanedSharesAfter() time.Duration {
+	if cfg.DeleteOrphanedSharesAfter == nil {
+		return 0
+	}
+	return cfg.DeleteOrphanedSharesAfter.Duration
+}
+
+// getQuotaWarningThreshold returns QuotaWarningThreshold or its default.
+func (cfg *operatorConfig) getQuotaWarningThreshold() int {
+	if cfg.QuotaWarningThreshold == nil {
+		return defaultQuotaWarningThreshold
+	}
+	return *cfg.QuotaWarningThreshold
+}
+
+func (o *storageClassOverride) validate() error {
+	var errs []string
+	if o.ReclaimPolicy != nil {
+		switch *o.ReclaimPolicy {
+		case corev1.PersistentVolumeReclaimDelete, corev1.PersistentVolumeReclaimRetain:
+		default:
+			errs = append(errs, fmt.Sprintf("unsupported reclaimPolicy %q", *o.ReclaimPolicy))
+		}
+	}
+	if o.VolumeBindingMode != nil {
+		switch *o.VolumeBindingMode {
+		case storagev1.VolumeBindingImmediate, storagev1.VolumeBindingWaitForFirstConsumer:
+		default:
+			errs = append(errs, fmt.Sprintf("unsupported volumeBindingMode %q", *o.VolumeBindingMode))
+		}
+	}
+	for _, key := range sortedKeys(o.Parameters) {
+		if key == "type" || key == appendShareMetadataParameter || strings.HasPrefix(key, "csi.storage.k8s.io/") {
+			errs = append(errs, fmt.Sprintf("parameter %q is managed by the operator", key))
+		}
+	}
+	for _, key := range sortedKeys(o.Labels) {
+		// The labels identify the owner of a generated StorageClass.
+		if key == util.ShareTypeIDLabel || key == util.ShareProtocolLabel {
+			errs = append(errs, fmt.Sprintf("label %q is managed by the operator", key))
+			continue
+		}
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, fmt.Sprintf("invalid label %q: %s", key, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(o.Labels[key]) {
+			errs = append(errs, fmt.Sprintf("invalid value of label %q: %s", key, msg))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// apply merges the override into the StorageClass.
+func (o *storageClassOverride) apply(sc *storagev1.StorageClass) {
+	if o.ReclaimPolicy != nil {
+		policy := *o.ReclaimPolicy
+		sc.ReclaimPolicy = &policy
+	}
+	if o.VolumeBindingMode != nil {
+		mode := *o.VolumeBindingMode
+		sc.VolumeBindingMode = &mode
+	}
+	if len(o.MountOptions) > 0 {
+		sc.MountOptions = append([]string{}, o.MountOptions...)
+	}
+	for k, v := range o.Parameters {
+		sc.Parameters[k] = v
+	}
+	for k, v := range o.Labels {
+		if sc.Labels == nil {
+			sc.Labels = map[string]string{}
+		}
+		sc.Labels[k] = v
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,154 @@
+package manila
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+func TestStorageClassOverride(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		config      string
+		expectError bool
+		expectValid bool
+		check       func(t *testing.T, sc *storagev1.StorageClass)
+	}{
+		{
+			name: "all fields",
+			config: `
+storageClasses:
+  gold:
+    reclaimPolicy: Retain
+    volumeBindingMode: WaitForFirstConsumer
+    mountOptions:
+    - vers=4.1
+    - nconnect=4
+    parameters:
+      nfs-shareClient: 10.0.0.0/16
+    labels:
+      tier: gold`,
+			expectValid: true,
+			check: func(t *testing.T, sc *storagev1.StorageClass) {
+				if *sc.ReclaimPolicy != corev1.PersistentVolumeReclaimRetain {
+					t.Errorf("unexpected reclaimPolicy %s", *sc.ReclaimPolicy)
+				}
+				if *sc.VolumeBindingMode != storagev1.VolumeBindingWaitForFirstConsumer {
+					t.Errorf("unexpected volumeBindingMode %s", *sc.VolumeBindingMode)
+				}
+				if !reflect.DeepEqual(sc.MountOptions, []string{"vers=4.1", "nconnect=4"}) {
+					t.Errorf("unexpected mountOptions %v", sc.MountOptions)
+				}
+				if sc.Parameters["nfs-shareClient"] != "10.0.0.0/16" || sc.Parameters["type"] != "gold" {
+					t.Errorf("unexpected parameters %v", sc.Parameters)
+				}
+				if sc.Labels["tier"] != "gold" || sc.Labels["manila.csi.openstack.org/share-type-id"] != "id1" {
+					t.Errorf("unexpected labels %v", sc.Labels)
+				}
+			},
+		},
+		{
+			name: "unknown field",
+			config: `
+storageClasses:
+  gold:
+    reclaimPolicyy: Retain`,
+			expectError: true,
+		},
+		{
+			name:        "invalid shareNetworkDiscovery",
+			config:      `shareNetworkDiscovery: Manual`,
+			expectError: true,
+		},
+		{
+			name:        "invalid NFS share client",
+			config:      `additionalNFSShareClients: [10.0.0.0/33]`,
+			expectError: true,
+		},
+		{
+			name:        "too short deleteOrphanedSharesAfter",
+			config:      `deleteOrphanedSharesAfter: 10m`,
+			expectError: true,
+		},
+		{
+			name:        "invalid uninstallAfterManilaGone",
+			config:      `uninstallAfterManilaGone: -1h`,
+			expectError: true,
+		},
+		{
+			name:        "invalid quotaWarningThreshold",
+			config:      `quotaWarningThreshold: 120`,
+			expectError: true,
+		},
+		{
+			name: "invalid values",
+			config: `
+storageClasses:
+  gold:
+    reclaimPolicy: Recycle
+    volumeBindingMode: Later`,
+		},
+		{
+			name: "operator owned parameter",
+			config: `
+storageClasses:
+  gold:
+    parameters:
+      csi.storage.k8s.io/provisioner-secret-name: foo`,
+		},
+		{
+			name: "share metadata parameter",
+			config: `
+storageClasses:
+  gold:
+    parameters:
+      appendShareMetadata: '{"foo": "bar"}'`,
+		},
+		{
+			name: "share protocol label",
+			config: `
+storageClasses:
+  gold:
+    labels:
+      manila.csi.openstack.org/share-protocol: CEPHFS`,
+		},
+		{
+			name: "invalid label",
+			config: `
+storageClasses:
+  gold:
+    labels:
+      "tier!": gold`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := parseOperatorConfig(tc.config)
+			if err != nil {
+				if !tc.expectError {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tc.expectError {
+				t.Fatalf("expected error, got none")
+			}
+
+			override := cfg.StorageClasses["gold"]
+			err = override.validate()
+			if tc.expectValid != (err == nil) {
+				t.Fatalf("expected valid: %v, got error: %v", tc.expectValid, err)
+			}
+			if err != nil {
+				return
+			}
+
+			c := &ManilaController{}
+			sc := c.generateStorageClass(sharetypes.ShareType{ID: "id1", Name: "gold"}, shareProtocolNFS, "csi-manila-gold")
+			override.apply(sc)
+			tc.check(t, sc)
+		})
+	}
+}
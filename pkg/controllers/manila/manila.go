@@ -5,85 +5,224 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
+	"github.com/gophercloud/utils/v2/openstack/clientconfig"
 	operatorv1 "github.com/openshift/api/operator/v1"
+	configinformers "github.com/openshift/client-go/config/informers/externalversions"
+	configlisters "github.com/openshift/client-go/config/listers/config/v1"
+	opinformers "github.com/openshift/client-go/operator/informers/externalversions"
+	operatorlisters "github.com/openshift/client-go/operator/listers/operator/v1"
 	"github.com/openshift/csi-driver-manila-operator/assets"
+	"github.com/openshift/csi-driver-manila-operator/pkg/controllers/secret"
 	"github.com/openshift/csi-driver-manila-operator/pkg/util"
 	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/csi/csistorageclasscontroller"
 	"github.com/openshift/library-go/pkg/operator/events"
 	"github.com/openshift/library-go/pkg/operator/resource/resourceapply"
 	"github.com/openshift/library-go/pkg/operator/resource/resourceread"
 	"github.com/openshift/library-go/pkg/operator/v1helpers"
 	corev1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8serrors "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	storagelisters "k8s.io/client-go/listers/storage/v1"
 	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
 )
 
 // This ManilaController watches OpenStack and:
 //  1. Installs Manila CSI drivers (Manila itself, NFS) once
 //     it detects that there is Manila present (by running provided
 //     manilaOperatorSet).
-//  2. Creates StorageClass for each share type provided by Manila.
-//  3. If there is no Manila in the OpenStack where the cluster runs,
+//  2. Creates StorageClass for each share type provided by Manila,
+//     according to StorageClassState of the ClusterCSIDriver.
+//  3. Creates VolumeSnapshotClass for each share type that supports
+//     snapshots.
+//  4. If there is no Manila in the OpenStack where the cluster runs,
 //     it marks the operator with condition Disabled=true.
+//  5. Stops the CSI driver controllers and removes the driver when the
+//     operator is Removed.
 //
 // Note that the CSI driver(s) are not un-installed when Manila becomes
 // missing or it stops providing shares of given type - Manila bight be
-// under (short?) maintenance / reconfiguration.
-// Similarly, StorageClasses are not deleted when a share type disappears
-// from Manila.
+// under (short?) maintenance / reconfiguration. The CephFS driver is removed
+// only after Manila stopped offering CephFS and nothing uses the driver. Only when the admin sets
+// uninstallAfterManilaGone in the operator config, the driver is removed
+// after Manila has been missing for that long.
+// StorageClasses of a share type that disappeared from Manila are deleted
+// only after a grace period and only when no PV / PVC uses them.
 type ManilaController struct {
-	operatorClient     v1helpers.OperatorClient
+	operatorClient     v1helpers.OperatorClientWithFinalizers
 	kubeClient         kubernetes.Interface
+	dynamicClient      dynamic.Interface
 	storageClassLister storagelisters.StorageClassLister
 	csiDriverLister    storagelisters.CSIDriverLister
-	// Controllers to start when Manila is detected
-	csiControllers     []Runnable
-	controllersRunning bool
-	eventRecorder      events.Recorder
+	pvLister           corelisters.PersistentVolumeLister
+	pvcLister          corelisters.PersistentVolumeClaimLister
+	configMapLister    corelisters.ConfigMapLister
+	// Lister of Secrets in util.OperandNamespace.
+	secretLister corelisters.SecretLister
+	// Lister of ConfigMaps in util.InstallConfigNamespace.
+	installConfigLister  corelisters.ConfigMapLister
+	nodeLister           corelisters.NodeLister
+	infraLister          configlisters.InfrastructureLister
+	clusterVersionLister configlisters.ClusterVersionLister
+	scStateEvaluator     *csistorageclasscontroller.StorageClassStateEvaluator
+	// Returns true when VolumeSnapshotClass CRD is installed.
+	volumeSnapshotCRDExists func() bool
+	// OpenStack client reused across syncs and share type polls and hash of
+	// clouds.yaml and CA bundle it was created from.
+	openstackClientLock sync.Mutex
+	openstackClient     *openStackClient
+	openstackConfigHash string
+	shareTypeCache      *shareTypeCache
+	// Func to stop the share type poll, nil when it's not running. Manila
+	// is polled only while the operator is Managed.
+	stopShareTypePoll context.CancelFunc
+	ccdLister         operatorlisters.ClusterCSIDriverLister
+	// The last seen value of refreshShareTypesAnnotation, nil before the
+	// first sync.
+	refreshAnnotation *string
+	// Manila API capabilities of the last share type poll, nil when unknown.
+	apiCapabilities *apiCapabilities
+	// ID of the last discovered share network, empty when unknown, and
+	// fetch time of the share type poll it was discovered after.
+	shareNetworkID   string
+	shareNetworkPoll time.Time
+	// IDs of orphaned shares deleted by the controller. They stay in the
+	// share type snapshot until the next poll and are forgotten once they
+	// are not listed anymore.
+	deletedOrphanIDs sets.Set[string]
+	// Builders of controllers to start when Manila is detected and func to
+	// stop them, nil when they are not running.
+	newCSIControllers  ControllerBuilder
+	stopCSIControllers func()
+	// Builders of controllers to start when a share type supports CephFS.
+	newCephFSControllers  ControllerBuilder
+	stopCephFSControllers func()
+	eventRecorder         events.Recorder
 }
 
 type Runnable interface {
 	Run(ctx context.Context, workers int)
 }
 
+// ControllerBuilder returns new instances of controllers. A stopped
+// controller can't be started again, its queue is shut down, so each start
+// gets new controllers.
+type ControllerBuilder func() ([]Runnable, error)
+
 const (
-	// Minimal interval between controller resyncs. The controller will detect
-	// new share types in Manila and create StorageClasses for them at least
-	// once per this interval.
+	// Minimal interval between controller resyncs. New share types in Manila
+	// are detected by shareTypeCache, which triggers a sync after each poll.
 	resyncInterval = 20 * time.Minute
 
 	operatorConditionPrefix = "ManilaController"
+
+	// Condition reporting that there is no Manila in the cloud.
+	disabledCondition = operatorConditionPrefix + "Disabled"
+
+	// Condition reporting that the controller can't get share types from
+	// Manila. Only a missing Manila endpoint is reported as Disabled
+	// condition, all other errors can be transient or they need to be fixed
+	// by the cluster admin.
+	openStackDegradedCondition = operatorConditionPrefix + "OpenStackDegraded"
+
+	// Condition reporting invalid StorageClass overrides in the operator
+	// ConfigMap.
+	storageClassConfigInvalidCondition = operatorConditionPrefix + "StorageClassConfigInvalid"
+	// Condition reporting share types whose StorageClass got a hash suffix
+	// or no StorageClass at all, because of an invalid or colliding name.
+	storageClassNameConflictCondition = operatorConditionPrefix + "StorageClassNameConflict"
+
+	defaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
 )
 
 func NewManilaController(
-	operatorClient v1helpers.OperatorClient,
+	operatorClient v1helpers.OperatorClientWithFinalizers,
 	kubeClient kubernetes.Interface,
+	dynamicClient dynamic.Interface,
 	informers v1helpers.KubeInformersForNamespaces,
-	csiControllers []Runnable,
+	configInformers configinformers.SharedInformerFactory,
+	operatorInformers opinformers.SharedInformerFactory,
+	volumeSnapshotCRDExists func() bool,
+	newCSIControllers ControllerBuilder,
+	newCephFSControllers ControllerBuilder,
 	eventRecorder events.Recorder) factory.Controller {
 
 	scInformer := informers.InformersFor("").Storage().V1().StorageClasses()
 	csiInformer := informers.InformersFor("").Storage().V1().CSIDrivers()
+	ccdInformer := operatorInformers.Operator().V1().ClusterCSIDrivers()
+	pvInformer := informers.InformersFor("").Core().V1().PersistentVolumes()
+	pvcInformer := informers.InformersFor("").Core().V1().PersistentVolumeClaims()
+	configMapInformer := informers.InformersFor(util.OperatorNamespace).Core().V1().ConfigMaps()
+	secretInformer := informers.InformersFor(util.OperandNamespace).Core().V1().Secrets()
+	installConfigInformer := informers.InformersFor(util.InstallConfigNamespace).Core().V1().ConfigMaps()
+	nodeInformer := informers.InformersFor("").Core().V1().Nodes()
+	infraInformer := configInformers.Config().V1().Infrastructures()
+	clusterVersionInformer := configInformers.Config().V1().ClusterVersions()
 	c := &ManilaController{
-		operatorClient:     operatorClient,
-		kubeClient:         kubeClient,
-		storageClassLister: scInformer.Lister(),
-		csiDriverLister:    csiInformer.Lister(),
-		csiControllers:     csiControllers,
-		eventRecorder:      eventRecorder.WithComponentSuffix("ManilaController"),
+		operatorClient:          operatorClient,
+		kubeClient:              kubeClient,
+		dynamicClient:           dynamicClient,
+		storageClassLister:      scInformer.Lister(),
+		csiDriverLister:         csiInformer.Lister(),
+		pvLister:                pvInformer.Lister(),
+		pvcLister:               pvcInformer.Lister(),
+		configMapLister:         configMapInformer.Lister(),
+		secretLister:            secretInformer.Lister(),
+		installConfigLister:     installConfigInformer.Lister(),
+		nodeLister:              nodeInformer.Lister(),
+		infraLister:             infraInformer.Lister(),
+		clusterVersionLister:    clusterVersionInformer.Lister(),
+		newCSIControllers:       newCSIControllers,
+		newCephFSControllers:    newCephFSControllers,
+		eventRecorder:           eventRecorder.WithComponentSuffix("ManilaController"),
+		volumeSnapshotCRDExists: volumeSnapshotCRDExists,
+		ccdLister:               ccdInformer.Lister(),
 	}
+	c.shareTypeCache = newShareTypeCache(getShareTypePollInterval(), c.fetchShareTypes)
+	c.scStateEvaluator = csistorageclasscontroller.NewStorageClassStateEvaluator(
+		kubeClient,
+		ccdInformer.Lister(),
+		c.eventRecorder,
+	)
 	return factory.New().WithSync(c.sync).WithSyncDegradedOnError(operatorClient).ResyncEvery(resyncInterval).WithInformers(
 		operatorClient.Informer(),
 		scInformer.Informer(),
 		csiInformer.Informer(),
+		ccdInformer.Informer(),
+	).WithFilteredEventsInformers(
+		factory.NamesFilter(operatorConfigMapName),
+		configMapInformer.Informer(),
+	).WithFilteredEventsInformers(
+		// Rotated credentials are picked up without waiting for the next
+		// share type poll, once SecretSyncController publishes them.
+		factory.NamesFilter(util.ManilaSecretName),
+		secretInformer.Informer(),
+	).WithFilteredEventsInformers(
+		factory.NamesFilter(util.InstallConfigName),
+		installConfigInformer.Informer(),
+	).WithBareInformers(
+		// PVs and PVCs are only checked before a StorageClass is deleted,
+		// their changes do not need to trigger a sync.
+		pvInformer.Informer(),
+		pvcInformer.Informer(),
+		// Nodes are used for share network discovery and availability
+		// zones, Infrastructure for share network discovery and share
+		// metadata, ClusterVersion for share metadata.
+		nodeInformer.Informer(),
+		infraInformer.Informer(),
+		clusterVersionInformer.Informer(),
 	).ToController("ManilaController", eventRecorder)
 }
 
@@ -95,45 +234,132 @@ func (c *ManilaController) sync(ctx context.Context, syncCtx factory.SyncContext
 	if err != nil {
 		return err
 	}
-	if opSpec.ManagementState != operatorv1.Managed {
+	switch opSpec.ManagementState {
+	case operatorv1.Managed:
+		c.startShareTypePollIfNeeded(ctx, syncCtx)
+	case operatorv1.Removed:
+		// Not in removeOperands, the poll must keep running when the
+		// operands are removed because Manila is gone, so the controller
+		// learns when it comes back.
+		c.stopShareTypePollIfRunning()
+		return c.removeOperands(ctx, "the operator is Removed")
+	default:
+		c.stopShareTypePollIfRunning()
 		return nil
 	}
 
-	openstackClient, err := NewOpenStackClient(util.CloudConfigFilename)
-	if err != nil {
-		return c.setDisabledCondition(ctx, fmt.Sprintf("Unable to connect to OpenStack: %v", err))
+	c.checkRefreshAnnotation()
+	if c.cloudConfigChanged() {
+		klog.V(2).Infof("OpenStack credentials changed, refreshing Manila share types")
+		c.shareTypeCache.refresh()
 	}
-	shareTypes, err := openstackClient.GetShareTypes()
-	if err != nil {
-		return c.setDisabledCondition(ctx, fmt.Sprintf("Unable to retrieve Manila share types: %v", err))
+	snapshot, pollErr := c.shareTypeCache.get()
+	if snapshot == nil {
+		if pollErr == nil {
+			klog.V(4).Infof("Waiting for the first poll of Manila share types")
+			return nil
+		}
+		// Failed polls are retried with backoff by shareTypeCache.
+		return c.reportOpenStackError(ctx, classifyOpenStackError(pollErr), fmt.Sprintf("Unable to retrieve Manila share types: %v", pollErr))
+	}
+	if classifyOpenStackError(pollErr) == reasonEndpointNotFound {
+		// Manila was removed from the cloud, the share types are not just
+		// stale.
+		return c.reportOpenStackError(ctx, reasonEndpointNotFound, fmt.Sprintf("Unable to retrieve Manila share types: %v", pollErr))
+	}
+	// Stale share types are still used, Manila may be unreachable only
+	// temporarily.
+	if err := c.reportShareTypesStaleness(ctx, snapshot, pollErr); err != nil {
+		return err
+	}
+	shareTypes := snapshot.shareTypes
+	shareTypesGauge.Set(float64(len(shareTypes)))
+	lastDiscoveryGauge.Set(float64(snapshot.fetched.Unix()))
+	c.apiCapabilities = snapshot.apiCapabilities
+	if err := c.reportAPICapabilities(ctx, snapshot.apiCapabilities); err != nil {
+		return err
 	}
 
 	if len(shareTypes) == 0 {
 		klog.V(4).Infof("Manila does not provide any share types")
-		return c.setDisabledCondition(ctx, "Manila does not provide any share types")
+		return c.reportOpenStackError(ctx, reasonNoShareTypes, "Manila does not provide any share types")
+	}
+	if err := c.reportPollError(ctx, snapshot, pollErr); err != nil {
+		return err
 	}
 	// Manila has some shares: start the actual CSI driver controller sets
-	if !c.controllersRunning {
+	if c.stopCSIControllers == nil {
 		klog.V(4).Infof("Starting CSI driver controllers")
-		for _, ctrl := range c.csiControllers {
-			go func(ctrl Runnable) {
-				defer utilruntime.HandleCrash()
-				ctrl.Run(ctx, 1)
-			}(ctrl)
+		if c.stopCSIControllers, err = startControllers(ctx, c.newCSIControllers); err != nil {
+			return err
+		}
+	}
+	protocols := getShareProtocols(shareTypes)
+	if protocols.Has(shareProtocolCephFS) && c.stopCephFSControllers == nil {
+		klog.V(4).Infof("Starting CephFS CSI driver controllers")
+		if c.stopCephFSControllers, err = startControllers(ctx, c.newCephFSControllers); err != nil {
+			return err
 		}
-		c.controllersRunning = true
 	}
 
-	err = c.syncCSIDriver(ctx)
+	err = c.syncCSIDriver(ctx, protocols)
+	if err != nil {
+		return err
+	}
+
+	cfg, cfgErr := c.getOperatorConfig()
+	var defaultShareTypeID string
+	if cfg.SetDefaultStorageClass {
+		defaultShareTypeID = snapshot.defaultShareTypeID
+	}
+
+	if err := c.reportQuotas(ctx, snapshot.quotas, cfg.getQuotaWarningThreshold()); err != nil {
+		return err
+	}
+
+	if err := c.syncOrphans(ctx, snapshot, cfg.getDeleteOrphanedSharesAfter()); err != nil {
+		return err
+	}
+
+	shareNetworkID, err := c.syncShareNetwork(ctx, cfg, snapshot)
+	if err != nil {
+		return err
+	}
+
+	machineNetworks, err := c.getMachineNetworks()
+	if err != nil {
+		return err
+	}
+
+	shareMetadata, err := c.getShareMetadata()
+	if err != nil {
+		return err
+	}
+
+	expectedSCs, err := c.syncStorageClasses(ctx, &storageClassInputs{
+		shareTypes:         shareTypes,
+		defaultShareTypeID: defaultShareTypeID,
+		shareNetworkID:     shareNetworkID,
+		nfsShareClients:    getNFSShareClients(machineNetworks, cfg),
+		shareMetadata:      shareMetadata,
+		cfg:                cfg,
+		cfgErr:             cfgErr,
+	})
 	if err != nil {
 		return err
 	}
 
-	err = c.syncStorageClasses(ctx, shareTypes)
+	storageClassesGauge.Set(float64(len(expectedSCs)))
+
+	err = c.syncVolumeSnapshotClasses(ctx, expectedSCs, snapshot.defaultShareTypeID, cfg)
 	if err != nil {
 		return err
 	}
 
+	if err := c.removeCephFSDriverIfUnused(ctx, protocols); err != nil {
+		return err
+	}
+
 	return c.setEnabledCondition(ctx)
 }
 
@@ -155,41 +381,318 @@ func (c *ManilaController) getFsGroupPolicy(ctx context.Context) storagev1.FSGro
 	return fsGroupPolicy
 }
 
-func (c *ManilaController) syncCSIDriver(ctx context.Context) error {
+// syncCSIDriver applies CSIDriver of the NFS driver instance, which is always
+// installed, and CSIDrivers of the other share protocols in use.
+func (c *ManilaController) syncCSIDriver(ctx context.Context, protocols sets.Set[string]) error {
 	klog.V(4).Infof("Starting CSI driver config refresh")
 	defer klog.V(4).Infof("CSI driver config refresh finished")
 
 	var errs []error
 
-	stream, e := assets.ReadFile("csidriver.yaml")
-	if e != nil {
-		panic("Error loading the CSIDriver resource")
+	assetNames := []string{"csidriver.yaml"}
+	if protocols.Has(shareProtocolCephFS) {
+		assetNames = append(assetNames, "cephfs/csidriver.yaml")
 	}
+	for _, assetName := range assetNames {
+		stream, e := assets.ReadFile(assetName)
+		if e != nil {
+			panic("Error loading the CSIDriver resource")
+		}
 
-	cr := resourceread.ReadCSIDriverV1OrDie(stream)
-	f := c.getFsGroupPolicy(ctx)
-	cr.Spec.FSGroupPolicy = &f
+		cr := resourceread.ReadCSIDriverV1OrDie(stream)
+		f := c.getFsGroupPolicy(ctx)
+		cr.Spec.FSGroupPolicy = &f
 
-	_, _, err := resourceapply.ApplyCSIDriver(ctx, c.kubeClient.StorageV1(), c.eventRecorder, cr)
+		_, _, err := resourceapply.ApplyCSIDriver(ctx, c.kubeClient.StorageV1(), c.eventRecorder, cr)
 
-	if err != nil {
-		errs = append(errs, err)
+		if err != nil {
+			errs = append(errs, err)
+		}
 	}
 
 	return k8serrors.NewAggregate(errs)
 }
 
-func (c *ManilaController) syncStorageClasses(ctx context.Context, shareTypes []sharetypes.ShareType) error {
-	var errs []error
-	for _, shareType := range shareTypes {
-		klog.V(4).Infof("Syncing storage class for shareType type %s", shareType.Name)
-		sc := c.generateStorageClass(shareType)
-		_, _, err := resourceapply.ApplyStorageClass(ctx, c.kubeClient.StorageV1(), c.eventRecorder, sc)
+// startShareTypePollIfNeeded starts polling Manila share types in the
+// background. Each poll triggers a sync.
+func (c *ManilaController) startShareTypePollIfNeeded(ctx context.Context, syncCtx factory.SyncContext) {
+	if c.stopShareTypePoll != nil {
+		return
+	}
+	klog.V(4).Infof("Starting Manila share type poll")
+	ctx, c.stopShareTypePoll = context.WithCancel(ctx)
+	go func() {
+		defer utilruntime.HandleCrash()
+		c.shareTypeCache.run(ctx, func() {
+			syncCtx.Queue().Add(syncCtx.QueueKey())
+		})
+	}()
+}
+
+func (c *ManilaController) stopShareTypePollIfRunning() {
+	if c.stopShareTypePoll == nil {
+		return
+	}
+	klog.V(4).Infof("Stopping Manila share type poll")
+	c.stopShareTypePoll()
+	c.stopShareTypePoll = nil
+}
+
+// startControllers runs new controllers until the returned func is called.
+// The func returns after the controllers exited, so no sync in progress can
+// re-create their operands after they're removed.
+func startControllers(ctx context.Context, newControllers ControllerBuilder) (func(), error) {
+	ctrls, err := newControllers()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, ctrl := range ctrls {
+		wg.Add(1)
+		go func(ctrl Runnable) {
+			defer wg.Done()
+			defer utilruntime.HandleCrash()
+			ctrl.Run(ctx, 1)
+		}(ctrl)
+	}
+	return func() {
+		cancel()
+		wg.Wait()
+	}, nil
+}
+
+// getOpenStackClient returns OpenStack client of the previous sync, so its
+// authenticated provider is reused. A new client is created only when
+// clouds.yaml or the CA bundle changes.
+func (c *ManilaController) getOpenStackClient() (*openStackClient, error) {
+	c.openstackClientLock.Lock()
+	defer c.openstackClientLock.Unlock()
+
+	cloudConfig, source, hash, err := c.getCloudConfig()
+	if err != nil {
+		return nil, err
+	}
+	if c.openstackClient != nil && c.openstackConfigHash == hash {
+		return c.openstackClient, nil
+	}
+
+	klog.V(2).Infof("Creating new OpenStack client from %s", source)
+	client, err := newOpenStackClientFromCloudConfig(cloudConfig, source)
+	if err != nil {
+		return nil, err
+	}
+	c.openstackClient = client
+	c.openstackConfigHash = hash
+	return client, nil
+}
+
+// cloudConfigChanged returns true when clouds.yaml or the CA bundle differ
+// from the ones the current OpenStack client was created from.
+func (c *ManilaController) cloudConfigChanged() bool {
+	c.openstackClientLock.Lock()
+	defer c.openstackClientLock.Unlock()
+
+	if c.openstackClient == nil {
+		return false
+	}
+	_, _, hash, err := c.getCloudConfig()
+	if err != nil {
+		// getOpenStackClient reports the error on the next poll.
+		return false
+	}
+	return hash != c.openstackConfigHash
+}
+
+// getCloudConfig returns clouds.yaml content, its source and hash of the
+// content together with the CA bundle. The content is built from the driver
+// Secret, which SecretSyncController publishes only with credentials that
+// Keystone did not reject, so the operator never switches to credentials the
+// driver does not use. The mounted clouds.yaml is used until the Secret is
+// published.
+func (c *ManilaController) getCloudConfig() (cloudConfig []byte, source string, hash string, err error) {
+	cert, err := getCloudProviderCert()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, "", "", err
+	}
+
+	source = fmt.Sprintf("secret %s/%s", util.OperandNamespace, util.ManilaSecretName)
+	driverSecret, err := c.secretLister.Secrets(util.OperandNamespace).Get(util.ManilaSecretName)
+	switch {
+	case err == nil:
+		cloudConfig, err = yaml.Marshal(clientconfig.Clouds{
+			Clouds: map[string]clientconfig.Cloud{util.CloudName: secret.ConfToCloud(driverSecret.Data)},
+		})
 		if err != nil {
-			errs = append(errs, err)
+			return nil, "", "", fmt.Errorf("failed to marshal credentials from %s: %w", source, err)
 		}
+	case errors.IsNotFound(err):
+		source = util.CloudConfigFilename
+		cloudConfig, err = os.ReadFile(util.CloudConfigFilename)
+		if err != nil {
+			return nil, "", "", err
+		}
+	default:
+		return nil, "", "", err
 	}
-	return k8serrors.NewAggregate(errs)
+	return cloudConfig, source, hashContent(cloudConfig, cert), nil
+}
+
+// storageClassInputs are inputs of StorageClass generation, gathered from
+// OpenStack and the cluster at the beginning of each sync.
+type storageClassInputs struct {
+	shareTypes []sharetypes.ShareType
+	// ID of Manila default share type. Empty when it should not be marked
+	// as the default StorageClass.
+	defaultShareTypeID string
+	// ID of share network for DHSS=true share types. Empty when share
+	// network discovery is disabled or it failed.
+	shareNetworkID string
+	// Clients allowed to access NFS shares. Empty allows everyone.
+	nfsShareClients []string
+	// appendShareMetadata StorageClass parameter. Empty when not known.
+	shareMetadata string
+	cfg           *operatorConfig
+	// Error reading cfg.
+	cfgErr error
+}
+
+// syncStorageClasses syncs StorageClasses of the share types and returns the
+// expected StorageClasses.
+func (c *ManilaController) syncStorageClasses(ctx context.Context, in *storageClassInputs) ([]*storagev1.StorageClass, error) {
+	shareTypes, cfg := in.shareTypes, in.cfg
+	// Managed: apply the StorageClasses, Unmanaged: leave them as they are,
+	// Removed: delete all StorageClasses created by the operator.
+	scState := c.scStateEvaluator.GetStorageClassState(string(operatorv1.ManilaCSIDriver))
+	klog.V(4).Infof("StorageClassState is %q", scState)
+
+	// Invalid overrides are reported in a condition and the StorageClasses
+	// are generated without them.
+	var configErrs []string
+	if in.cfgErr != nil {
+		configErrs = append(configErrs, in.cfgErr.Error())
+	}
+	shareTypeNames := sets.New[string]()
+	for _, shareType := range shareTypes {
+		shareTypeNames.Insert(shareType.Name)
+	}
+	for _, name := range sortedKeys(cfg.StorageClasses) {
+		if !shareTypeNames.Has(name) {
+			configErrs = append(configErrs, fmt.Sprintf("share type %q: not found in Manila", name))
+		}
+	}
+
+	nodeZones, err := c.getNodeZones()
+	if err != nil {
+		return nil, err
+	}
+	var supportedShareTypes []sharetypes.ShareType
+	var zonesUnavailable []string
+	shareTypeZones := map[string][]string{}
+	for _, shareType := range shareTypes {
+		capabilities := c.shareTypeCapabilities(shareType)
+		if len(capabilities.Protocols) == 0 {
+			klog.V(2).Infof("Skipping share type %s: none of its protocols is supported", shareType.Name)
+			continue
+		}
+		if capabilities.DriverHandlesShareServers && in.shareNetworkID == "" {
+			// The driver can't provision shares of DHSS=true share types
+			// without shareNetworkID StorageClass parameter.
+			klog.V(2).Infof("Skipping share type %s: share types with %s=True need a share network", shareType.Name, extraSpecDHSS)
+			continue
+		}
+		if len(capabilities.AvailabilityZones) > 0 {
+			zones := getShareTypeZones(capabilities.AvailabilityZones, nodeZones)
+			if len(zones) == 0 {
+				klog.Warningf("Skipping share type %s: none of its availability zones %v has any node", shareType.Name, capabilities.AvailabilityZones)
+				zonesUnavailable = append(zonesUnavailable, fmt.Sprintf("%s (%s)", shareType.Name, strings.Join(capabilities.AvailabilityZones, ",")))
+				continue
+			}
+			shareTypeZones[shareType.ID] = zones
+		}
+		supportedShareTypes = append(supportedShareTypes, shareType)
+	}
+	// Each share type gets a StorageClass per protocol.
+	var protocolShareTypes []sharetypes.ShareType
+	for _, shareType := range supportedShareTypes {
+		for _, protocol := range c.shareTypeCapabilities(shareType).Protocols {
+			protocolShareTypes = append(protocolShareTypes, protocolShareType(shareType, protocol))
+		}
+	}
+	scNames, err := c.generateStorageClassNames(protocolShareTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	var expectedSCs []*storagev1.StorageClass
+	var worldAccessible []string
+	for _, shareType := range supportedShareTypes {
+		override, hasOverride := cfg.StorageClasses[shareType.Name]
+		if hasOverride {
+			if err := override.validate(); err != nil {
+				configErrs = append(configErrs, fmt.Sprintf("share type %q: %v", shareType.Name, err))
+				hasOverride = false
+			}
+		}
+		capabilities := c.shareTypeCapabilities(shareType)
+		for i, protocol := range capabilities.Protocols {
+			scName, ok := scNames.names[storageClassKey(shareType.ID, protocol)]
+			if !ok {
+				continue
+			}
+			klog.V(4).Infof("Syncing %s storage class for shareType type %s", protocol, shareType.Name)
+			sc := c.generateStorageClass(shareType, protocol, scName)
+			if capabilities.DriverHandlesShareServers {
+				sc.Parameters[shareNetworkIDParameter] = in.shareNetworkID
+			}
+			if in.shareMetadata != "" && c.apiCapabilities.has(capabilityShareMetadata) {
+				sc.Parameters[appendShareMetadataParameter] = in.shareMetadata
+			}
+			if protocol == shareProtocolNFS && len(in.nfsShareClients) > 0 {
+				sc.Parameters[nfsShareClientParameter] = strings.Join(in.nfsShareClients, ",")
+			}
+			applyTopology(sc, shareTypeZones[shareType.ID])
+			if hasOverride {
+				override.apply(sc)
+			}
+			if protocol == shareProtocolNFS && isWorldAccessible(sc) {
+				worldAccessible = append(worldAccessible, sc.Name)
+			}
+			// Only StorageClass of the preferred protocol can be the default.
+			if i == 0 && shareType.ID == in.defaultShareTypeID {
+				if err := c.setDefaultStorageClass(sc); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			expectedSCs = append(expectedSCs, sc)
+			err := c.scStateEvaluator.ApplyStorageClass(ctx, sc, scState)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if err := c.removeStaleStorageClasses(ctx, expectedSCs, scState); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.updateCondition(ctx, storageClassConfigInvalidCondition, "InvalidConfig", strings.Join(configErrs, "; ")); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.reportStorageClassNames(ctx, scNames); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.reportUnavailableZones(ctx, zonesUnavailable); err != nil {
+		errs = append(errs, err)
+	}
+	var worldAccessibleMsg string
+	if len(worldAccessible) > 0 {
+		worldAccessibleMsg = fmt.Sprintf("NFS shares of StorageClasses %s can be mounted from any address, set %s parameter or the machine network", strings.Join(worldAccessible, ", "), nfsShareClientParameter)
+	}
+	if err := c.updateCondition(ctx, worldAccessibleCondition, "WorldAccessible", worldAccessibleMsg); err != nil {
+		errs = append(errs, err)
+	}
+	return expectedSCs, k8serrors.NewAggregate(errs)
 }
 
 func (c *ManilaController) applyStorageClass(ctx context.Context, expected *storagev1.StorageClass) error {
@@ -197,18 +700,41 @@ func (c *ManilaController) applyStorageClass(ctx context.Context, expected *stor
 	return err
 }
 
-func (c *ManilaController) generateStorageClass(shareType sharetypes.ShareType) *storagev1.StorageClass {
-	/* As per RFC 1123 the storage class name must consist of lower case alphanumeric character,  '-' or '.'
-	   and must start and end with an alphanumeric character.
-	*/
-	storageClassName := util.StorageClassNamePrefix + strings.ToLower(strings.Replace(shareType.Name, "_", "-", -1))
+// reportStorageClassNames reports share types with renamed or skipped
+// StorageClasses in a condition. An event is emitted when the report changes.
+func (c *ManilaController) reportStorageClassNames(ctx context.Context, scNames *storageClassNames) error {
+	msg := scNames.message()
+	_, status, _, err := c.operatorClient.GetOperatorState()
+	if err != nil {
+		return err
+	}
+	if msg != "" {
+		existing := v1helpers.FindOperatorCondition(status.Conditions, storageClassNameConflictCondition)
+		if existing == nil || existing.Message != msg {
+			c.eventRecorder.Warningf("StorageClassNameConflict", "%s", msg)
+		}
+	}
+	return c.updateCondition(ctx, storageClassNameConflictCondition, "NameConflict", msg)
+}
+
+// generateStorageClass generates StorageClass for the share type. Use
+// generateStorageClassNames to get a valid and unique StorageClass name.
+func (c *ManilaController) generateStorageClass(shareType sharetypes.ShareType, protocol, storageClassName string) *storagev1.StorageClass {
 	delete := corev1.PersistentVolumeReclaimDelete
 	immediate := storagev1.VolumeBindingImmediate
+	caps := c.shareTypeCapabilities(shareType)
+	annotations := caps.annotations()
+	annotations[shareTypeNameAnnotation] = shareType.Name
 	sc := &storagev1.StorageClass{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: storageClassName,
+			Labels: map[string]string{
+				util.ShareTypeIDLabel:   shareType.ID,
+				util.ShareProtocolLabel: protocol,
+			},
+			Annotations: annotations,
 		},
-		Provisioner: "manila.csi.openstack.org",
+		Provisioner: shareProtocolDrivers[protocol],
 		Parameters: map[string]string{
 			"type": shareType.Name,
 			"csi.storage.k8s.io/provisioner-secret-name":       util.ManilaSecretName,
@@ -218,36 +744,89 @@ func (c *ManilaController) generateStorageClass(shareType sharetypes.ShareType)
 			"csi.storage.k8s.io/node-publish-secret-name":      util.ManilaSecretName,
 			"csi.storage.k8s.io/node-publish-secret-namespace": util.OperandNamespace,
 		},
-		ReclaimPolicy:     &delete,
-		VolumeBindingMode: &immediate,
+		ReclaimPolicy:        &delete,
+		VolumeBindingMode:    &immediate,
+		AllowVolumeExpansion: &caps.ExtendSupport,
 	}
 	return sc
 }
 
+// setDefaultStorageClass marks the StorageClass as the cluster default, unless
+// there already is another default StorageClass. The default StorageClass
+// annotation of an existing StorageClass is always preserved, so an admin
+// can override it.
+func (c *ManilaController) setDefaultStorageClass(sc *storagev1.StorageClass) error {
+	metav1.SetMetaDataAnnotation(&sc.ObjectMeta, defaultStorageClassAnnotation, "true")
+	return csistorageclasscontroller.SetDefaultStorageClass(c.storageClassLister, sc)
+}
+
 func (c *ManilaController) setEnabledCondition(ctx context.Context) error {
 	_, _, err := v1helpers.UpdateStatus(
 		ctx,
 		c.operatorClient,
-		removeConditionFn(operatorConditionPrefix+"Disabled"),
+		removeConditionFn(disabledCondition),
 	)
 	return err
 }
 
-func (c *ManilaController) setDisabledCondition(ctx context.Context, msg string) error {
+func (c *ManilaController) setDisabledCondition(ctx context.Context, reason, msg string) error {
 	disabledCnd := operatorv1.OperatorCondition{
-		Type:    operatorConditionPrefix + "Disabled",
+		Type:    disabledCondition,
 		Status:  operatorv1.ConditionTrue,
-		Reason:  "NoManila",
+		Reason:  reason,
 		Message: msg,
 	}
 	_, _, err := v1helpers.UpdateStatus(
 		ctx,
 		c.operatorClient,
 		v1helpers.UpdateConditionFn(disabledCnd),
+		removeConditionFn(openStackDegradedCondition),
 	)
 	return err
 }
 
+// reportOpenStackError reports failure to get share types from Manila. Missing
+// Manila endpoint means there is no Manila in the cloud and the controller is
+// disabled. Other errors are reported as degraded. Disabled condition is left
+// as it is, it's not known if Manila is present.
+func (c *ManilaController) reportOpenStackError(ctx context.Context, reason, msg string) error {
+	klog.V(2).Infof("%s: %s", reason, msg)
+	if reason == reasonEndpointNotFound {
+		if err := c.setDisabledCondition(ctx, reason, msg); err != nil {
+			return err
+		}
+		return c.uninstallIfManilaGone(ctx)
+	}
+	return c.updateCondition(ctx, openStackDegradedCondition, reason, msg)
+}
+
+// updateCondition sets condition of given type to True with the message.
+// When the message is empty, the condition is removed.
+func (c *ManilaController) updateCondition(ctx context.Context, cndType, reason, msg string) error {
+	updateFn := removeConditionFn(cndType)
+	if msg != "" {
+		updateFn = v1helpers.UpdateConditionFn(operatorv1.OperatorCondition{
+			Type:    cndType,
+			Status:  operatorv1.ConditionTrue,
+			Reason:  reason,
+			Message: msg,
+		})
+	}
+	_, _, err := v1helpers.UpdateStatus(ctx, c.operatorClient, updateFn)
+	return err
+}
+
+func (c *ManilaController) setCondition(ctx context.Context, cndType string, status operatorv1.ConditionStatus, reason, msg string) error {
+	cnd := operatorv1.OperatorCondition{
+		Type:    cndType,
+		Status:  status,
+		Reason:  reason,
+		Message: msg,
+	}
+	_, _, err := v1helpers.UpdateStatus(ctx, c.operatorClient, v1helpers.UpdateConditionFn(cnd))
+	return err
+}
+
 func removeConditionFn(cnd string) v1helpers.UpdateStatusFunc {
 	return func(oldStatus *operatorv1.OperatorStatus) error {
 		v1helpers.RemoveOperatorCondition(&oldStatus.Conditions, cnd)
@@ -0,0 +1,61 @@
+package manila
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestSetDefaultStorageClass(t *testing.T) {
+	shareType := sharetypes.ShareType{ID: "id1", Name: "default"}
+	scName := "csi-manila-default"
+
+	for _, tc := range []struct {
+		name     string
+		objects  []runtime.Object
+		expected string
+	}{
+		{
+			name:     "no default StorageClass",
+			expected: "true",
+		},
+		{
+			name: "another default StorageClass",
+			objects: []runtime.Object{
+				&storagev1.StorageClass{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "standard-csi",
+						Annotations: map[string]string{defaultStorageClassAnnotation: "true"},
+					},
+				},
+			},
+			expected: "false",
+		},
+		{
+			name: "admin unset the default",
+			objects: []runtime.Object{
+				&storagev1.StorageClass{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        scName,
+						Annotations: map[string]string{defaultStorageClassAnnotation: "false"},
+					},
+				},
+			},
+			expected: "false",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestController(tc.objects...)
+			sc := c.generateStorageClass(shareType, shareProtocolNFS, scName)
+			if err := c.setDefaultStorageClass(sc); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value := sc.Annotations[defaultStorageClassAnnotation]; value != tc.expected {
+				t.Errorf("expected default StorageClass annotation %q, got %q", tc.expected, value)
+			}
+		})
+	}
+}
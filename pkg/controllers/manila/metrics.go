@@ -0,0 +1,176 @@
+package manila
+
+import (
+	"strings"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+// Metrics of the operator. They're registered in the legacy registry, which
+// is served by the operator on /metrics.
+const metricsPrefix = "openshift_manila_csi_driver_operator_"
+
+var (
+	keystoneAuthAttempts = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Name:           metricsPrefix + "keystone_auth_attempts_total",
+			Help:           "Number of authentications to Keystone, including re-authentications of expired tokens.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	keystoneAuthFailures = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Name:           metricsPrefix + "keystone_auth_failures_total",
+			Help:           "Number of failed authentications to Keystone by reason.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"reason"},
+	)
+	manilaRequestDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Name:           metricsPrefix + "manila_api_request_duration_seconds",
+			Help:           "Duration of Manila API calls by call and result (success or error).",
+			Buckets:        []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"call", "result"},
+	)
+	shareTypesGauge = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name:           metricsPrefix + "share_types",
+			Help:           "Number of share types discovered in Manila.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	storageClassesGauge = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name:           metricsPrefix + "storage_classes",
+			Help:           "Number of StorageClasses generated for the Manila share types.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	lastDiscoveryGauge = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name:           metricsPrefix + "share_type_discovery_last_success_timestamp_seconds",
+			Help:           "Time of the last successful discovery of Manila share types, in seconds since the epoch.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	quotaLimitGauge = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name:           metricsPrefix + "quota_limit",
+			Help:           "Manila quota of the cluster project. Without share_type label it's the project quota, otherwise quota of the share type. Unlimited quotas are not reported.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"resource", "share_type"},
+	)
+	orphanedSharesGauge = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name:           metricsPrefix + "orphaned_shares",
+			Help:           "Number of Manila shares tagged with the cluster infrastructure name that have no PersistentVolume.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	danglingPVsGauge = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name:           metricsPrefix + "dangling_persistent_volumes",
+			Help:           "Number of Manila PersistentVolumes whose share does not exist in Manila.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	quotaUsageGauge = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name:           metricsPrefix + "quota_usage",
+			Help:           "Usage of Manila quota of the cluster project. Without share_type label it's usage of the project quota, otherwise usage of the share type quota.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"resource", "share_type"},
+	)
+)
+
+// Conditions of the ClusterCSIDriver, read from the operator status on each
+// scrape, so alerts can use them.
+var operatorConditionDesc = metrics.NewDesc(
+	metricsPrefix+"condition",
+	"Disabled and Degraded conditions of the ClusterCSIDriver, 1 when the condition is True, 0 otherwise.",
+	[]string{"condition", "reason"},
+	nil,
+	metrics.ALPHA,
+	"",
+)
+
+func init() {
+	legacyregistry.MustRegister(
+		keystoneAuthAttempts,
+		keystoneAuthFailures,
+		manilaRequestDuration,
+		shareTypesGauge,
+		storageClassesGauge,
+		lastDiscoveryGauge,
+		quotaLimitGauge,
+		quotaUsageGauge,
+		orphanedSharesGauge,
+		danglingPVsGauge,
+	)
+}
+
+// observeKeystoneAuth records result of an authentication to Keystone.
+func observeKeystoneAuth(err error) {
+	keystoneAuthAttempts.Inc()
+	if err != nil {
+		keystoneAuthFailures.WithLabelValues(classifyOpenStackError(err)).Inc()
+	}
+}
+
+// observeManilaRequest records duration and result of a Manila API call
+// started at start.
+func observeManilaRequest(call string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	manilaRequestDuration.WithLabelValues(call, result).Observe(time.Since(start).Seconds())
+}
+
+// conditionCollector exports Disabled and Degraded conditions of the operator
+// as operatorConditionDesc metric.
+type conditionCollector struct {
+	metrics.BaseStableCollector
+
+	operatorClient v1helpers.OperatorClient
+}
+
+var _ metrics.StableCollector = &conditionCollector{}
+
+// RegisterConditionMetrics registers metrics with conditions of the operator
+// in the legacy registry.
+func RegisterConditionMetrics(operatorClient v1helpers.OperatorClient) {
+	legacyregistry.CustomMustRegister(&conditionCollector{operatorClient: operatorClient})
+}
+
+func (c *conditionCollector) DescribeWithStability(ch chan<- *metrics.Desc) {
+	ch <- operatorConditionDesc
+}
+
+func (c *conditionCollector) CollectWithStability(ch chan<- metrics.Metric) {
+	_, status, _, err := c.operatorClient.GetOperatorState()
+	if err != nil {
+		klog.Warningf("Failed to get operator status for metrics: %v", err)
+		return
+	}
+	for _, cnd := range status.Conditions {
+		if cnd.Type != disabledCondition && !strings.HasSuffix(cnd.Type, operatorv1.OperatorStatusTypeDegraded) {
+			continue
+		}
+		var value float64
+		if cnd.Status == operatorv1.ConditionTrue {
+			value = 1
+		}
+		ch <- metrics.NewLazyConstMetric(operatorConditionDesc, metrics.GaugeValue, value, cnd.Type, cnd.Reason)
+	}
+}
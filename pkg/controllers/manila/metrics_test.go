@@ -0,0 +1,64 @@
+package manila
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/component-base/metrics"
+)
+
+func TestObserveKeystoneAuth(t *testing.T) {
+	keystoneAuthAttempts.Reset()
+	keystoneAuthFailures.Reset()
+
+	observeKeystoneAuth(nil)
+	observeKeystoneAuth(gophercloud.ErrUnexpectedResponseCode{Actual: 401})
+
+	if attempts := testutil.ToFloat64(keystoneAuthAttempts); attempts != 2 {
+		t.Errorf("expected 2 attempts, got %v", attempts)
+	}
+	if count := testutil.CollectAndCount(keystoneAuthFailures); count != 1 {
+		t.Errorf("expected 1 failure reason, got %d", count)
+	}
+}
+
+func TestObserveManilaRequest(t *testing.T) {
+	manilaRequestDuration.Reset()
+
+	start := time.Now()
+	observeManilaRequest("ListShareTypes", start, nil)
+	observeManilaRequest("ListShareTypes", start, nil)
+	observeManilaRequest("ListShareTypes", start, errors.New("boom"))
+
+	if count := testutil.CollectAndCount(manilaRequestDuration); count != 2 {
+		t.Errorf("expected 2 series, got %d", count)
+	}
+}
+
+func TestConditionCollector(t *testing.T) {
+	operatorClient := v1helpers.NewFakeOperatorClient(&operatorv1.OperatorSpec{}, &operatorv1.OperatorStatus{
+		Conditions: []operatorv1.OperatorCondition{
+			{Type: disabledCondition, Status: operatorv1.ConditionTrue, Reason: reasonEndpointNotFound},
+			{Type: openStackDegradedCondition, Status: operatorv1.ConditionFalse, Reason: "AsExpected"},
+			{Type: quotaWarningCondition, Status: operatorv1.ConditionTrue, Reason: "QuotaThresholdExceeded"},
+		},
+	}, nil)
+	registry := metrics.NewKubeRegistry()
+	registry.CustomMustRegister(&conditionCollector{operatorClient: operatorClient})
+
+	expected := `
+# HELP openshift_manila_csi_driver_operator_condition [ALPHA] Disabled and Degraded conditions of the ClusterCSIDriver, 1 when the condition is True, 0 otherwise.
+# TYPE openshift_manila_csi_driver_operator_condition gauge
+openshift_manila_csi_driver_operator_condition{condition="ManilaControllerDisabled",reason="EndpointNotFound"} 1
+openshift_manila_csi_driver_operator_condition{condition="ManilaControllerOpenStackDegraded",reason="AsExpected"} 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), metricsPrefix+"condition"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,192 @@
+package manila
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Condition with Manila API microversions and capabilities detected by the
+// controller.
+const apiCapabilitiesCondition = operatorConditionPrefix + "APICapabilities"
+
+// Manila API capabilities that depend on the microversion of the cloud.
+const (
+	capabilityExtend             = "Extend"
+	capabilityShrink             = "Shrink"
+	capabilitySnapshots          = "Snapshots"
+	capabilitySnapshotRevert     = "SnapshotRevert"
+	capabilityCreateFromSnapshot = "CreateFromSnapshot"
+	capabilityShareMetadata      = "ShareMetadata"
+	capabilityAZAwareShareTypes  = "AZAwareShareTypes"
+	capabilityShareTypeQuotas    = "ShareTypeQuotas"
+)
+
+// Minimal microversions of the capabilities, ordered by name.
+// See https://docs.openstack.org/manila/latest/contributor/api_microversion_history.html
+var capabilityMicroversions = []struct {
+	name    string
+	version microversion
+}{
+	// availability_zones share type extra spec.
+	{capabilityAZAwareShareTypes, microversion{2, 48}},
+	// create_share_from_snapshot_support share type extra spec.
+	{capabilityCreateFromSnapshot, microversion{2, 24}},
+	// "extend" share action, "os-extend" before.
+	{capabilityExtend, microversion{2, 7}},
+	{capabilityShareMetadata, microversion{2, 0}},
+	// Quotas per share type.
+	{capabilityShareTypeQuotas, microversion{2, 39}},
+	{capabilityShrink, microversion{2, 7}},
+	{capabilitySnapshotRevert, microversion{2, 27}},
+	// snapshot_support share type extra spec.
+	{capabilitySnapshots, microversion{2, 2}},
+}
+
+// The highest microversion the operator uses. Higher microversions of the
+// cloud bring nothing the operator needs and may change the API responses.
+var maxOperatorMicroversion = microversion{2, 48}
+
+// microversion is Manila API microversion, "<major>.<minor>".
+type microversion struct {
+	major, minor int
+}
+
+// parseMicroversion parses microversion reported by Manila. Empty string,
+// reported by API versions without microversions, is 2.0.
+func parseMicroversion(s string) (microversion, error) {
+	if s == "" {
+		return microversion{2, 0}, nil
+	}
+	majorStr, minorStr, ok := strings.Cut(s, ".")
+	if !ok {
+		return microversion{}, fmt.Errorf("invalid microversion %q", s)
+	}
+	major, err := strconv.Atoi(majorStr)
+	if err != nil {
+		return microversion{}, fmt.Errorf("invalid microversion %q", s)
+	}
+	minor, err := strconv.Atoi(minorStr)
+	if err != nil {
+		return microversion{}, fmt.Errorf("invalid microversion %q", s)
+	}
+	return microversion{major, minor}, nil
+}
+
+func (v microversion) less(other microversion) bool {
+	if v.major != other.major {
+		return v.major < other.major
+	}
+	return v.minor < other.minor
+}
+
+func (v microversion) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+// apiCapabilities are Manila API microversions supported by the cloud, the
+// microversion negotiated by the operator and capabilities available in the
+// cloud.
+type apiCapabilities struct {
+	minVersion microversion
+	maxVersion microversion
+	// Microversion used by the operator.
+	version   microversion
+	supported sets.Set[string]
+}
+
+// newAPICapabilities returns capabilities of a cloud with the given minimum
+// and maximum microversions.
+func newAPICapabilities(minVersionStr, maxVersionStr string) (*apiCapabilities, error) {
+	minVersion, err := parseMicroversion(minVersionStr)
+	if err != nil {
+		return nil, err
+	}
+	maxVersion, err := parseMicroversion(maxVersionStr)
+	if err != nil {
+		return nil, err
+	}
+	if maxVersion.less(minVersion) {
+		return nil, fmt.Errorf("maximum microversion %s is lower than minimum microversion %s", maxVersion, minVersion)
+	}
+
+	caps := &apiCapabilities{
+		minVersion: minVersion,
+		maxVersion: maxVersion,
+		version:    maxVersion,
+		supported:  sets.New[string](),
+	}
+	if maxOperatorMicroversion.less(maxVersion) {
+		caps.version = maxOperatorMicroversion
+	}
+	if caps.version.less(minVersion) {
+		// The cloud does not support microversions known to the operator.
+		caps.version = minVersion
+	}
+	for _, cm := range capabilityMicroversions {
+		if !maxVersion.less(cm.version) {
+			caps.supported.Insert(cm.name)
+		}
+	}
+	return caps, nil
+}
+
+// has returns true when the cloud supports the capability. Unknown
+// capabilities (nil) support everything, so a failed detection does not
+// disable features that may work.
+func (caps *apiCapabilities) has(capability string) bool {
+	return caps == nil || caps.supported.Has(capability)
+}
+
+func (caps *apiCapabilities) String() string {
+	var supported, unsupported []string
+	for _, cm := range capabilityMicroversions {
+		if caps.supported.Has(cm.name) {
+			supported = append(supported, cm.name)
+		} else {
+			unsupported = append(unsupported, fmt.Sprintf("%s (needs %s)", cm.name, cm.version))
+		}
+	}
+	msg := fmt.Sprintf("Manila API microversions %s - %s, using %s. Supported: %s", caps.minVersion, caps.maxVersion, caps.version, strings.Join(supported, ", "))
+	if len(unsupported) > 0 {
+		msg += fmt.Sprintf(". Unsupported: %s", strings.Join(unsupported, ", "))
+	}
+	return msg
+}
+
+// restrict removes capabilities of the share type that Manila API of the cloud
+// can't use.
+func (caps shareTypeCapabilities) restrict(api *apiCapabilities) shareTypeCapabilities {
+	if !api.has(capabilitySnapshots) {
+		caps.SnapshotSupport = false
+	}
+	if !api.has(capabilityCreateFromSnapshot) {
+		caps.CreateFromSnapshotSupport = false
+	}
+	if !api.has(capabilityExtend) {
+		caps.ExtendSupport = false
+	}
+	if !api.has(capabilityAZAwareShareTypes) {
+		caps.AvailabilityZones = nil
+	}
+	return caps
+}
+
+// shareTypeCapabilities returns capabilities of the share type usable with
+// the cloud's Manila API.
+func (c *ManilaController) shareTypeCapabilities(shareType sharetypes.ShareType) shareTypeCapabilities {
+	return getShareTypeCapabilities(shareType).restrict(c.apiCapabilities)
+}
+
+// reportAPICapabilities reports the detected capabilities in a condition.
+func (c *ManilaController) reportAPICapabilities(ctx context.Context, caps *apiCapabilities) error {
+	if caps == nil {
+		return c.setCondition(ctx, apiCapabilitiesCondition, operatorv1.ConditionUnknown, "DetectionFailed", "Unable to detect Manila API microversions, all capabilities are assumed")
+	}
+	return c.setCondition(ctx, apiCapabilitiesCondition, operatorv1.ConditionTrue, "Detected", caps.String())
+}
@@ -0,0 +1,124 @@
+package manila
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestNewAPICapabilities(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		minVersion        string
+		maxVersion        string
+		expectError       bool
+		expectedVersion   string
+		expectedSupported []string
+	}{
+		{
+			name:            "recent cloud",
+			minVersion:      "2.0",
+			maxVersion:      "2.78",
+			expectedVersion: "2.48",
+			expectedSupported: []string{
+				capabilityAZAwareShareTypes,
+				capabilityCreateFromSnapshot,
+				capabilityExtend,
+				capabilityShareMetadata,
+				capabilityShareTypeQuotas,
+				capabilityShrink,
+				capabilitySnapshotRevert,
+				capabilitySnapshots,
+			},
+		},
+		{
+			name:            "old cloud",
+			minVersion:      "2.0",
+			maxVersion:      "2.25",
+			expectedVersion: "2.25",
+			expectedSupported: []string{
+				capabilityCreateFromSnapshot,
+				capabilityExtend,
+				capabilityShareMetadata,
+				capabilityShrink,
+				capabilitySnapshots,
+			},
+		},
+		{
+			name:              "no microversions",
+			expectedVersion:   "2.0",
+			expectedSupported: []string{capabilityShareMetadata},
+		},
+		{
+			name:            "minimum above the operator maximum",
+			minVersion:      "2.50",
+			maxVersion:      "2.60",
+			expectedVersion: "2.50",
+			expectedSupported: []string{
+				capabilityAZAwareShareTypes,
+				capabilityCreateFromSnapshot,
+				capabilityExtend,
+				capabilityShareMetadata,
+				capabilityShareTypeQuotas,
+				capabilityShrink,
+				capabilitySnapshotRevert,
+				capabilitySnapshots,
+			},
+		},
+		{
+			name:        "invalid version",
+			minVersion:  "2.0",
+			maxVersion:  "latest",
+			expectError: true,
+		},
+		{
+			name:        "maximum below minimum",
+			minVersion:  "2.10",
+			maxVersion:  "2.9",
+			expectError: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			caps, err := newAPICapabilities(tc.minVersion, tc.maxVersion)
+			if err != nil {
+				if !tc.expectError {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tc.expectError {
+				t.Fatalf("expected error, got none")
+			}
+			if caps.version.String() != tc.expectedVersion {
+				t.Errorf("expected version %s, got %s", tc.expectedVersion, caps.version)
+			}
+			if supported := sets.List(caps.supported); !reflect.DeepEqual(supported, tc.expectedSupported) {
+				t.Errorf("expected capabilities %v, got %v", tc.expectedSupported, supported)
+			}
+		})
+	}
+}
+
+func TestRestrictShareTypeCapabilities(t *testing.T) {
+	stCaps := shareTypeCapabilities{
+		SnapshotSupport:           true,
+		CreateFromSnapshotSupport: true,
+		ExtendSupport:             true,
+		AvailabilityZones:         []string{"az1"},
+		Protocols:                 []string{shareProtocolNFS},
+	}
+
+	if restricted := stCaps.restrict(nil); !reflect.DeepEqual(restricted, stCaps) {
+		t.Errorf("expected unknown API capabilities to keep %+v, got %+v", stCaps, restricted)
+	}
+
+	apiCaps, err := newAPICapabilities("2.0", "2.1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := shareTypeCapabilities{Protocols: []string{shareProtocolNFS}}
+	if restricted := stCaps.restrict(apiCaps); !reflect.DeepEqual(restricted, expected) {
+		t.Errorf("expected %+v, got %+v", expected, restricted)
+	}
+}
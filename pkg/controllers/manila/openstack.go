@@ -2,31 +2,63 @@ package manila
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/gophercloud/gophercloud/v2"
 	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/apiversions"
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharenetworks"
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/shares"
 	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
 	"github.com/gophercloud/utils/v2/openstack/clientconfig"
 	"github.com/openshift/csi-driver-manila-operator/pkg/util"
 	"github.com/openshift/csi-driver-manila-operator/pkg/version"
+	"k8s.io/klog/v2"
 	"sigs.k8s.io/yaml"
 )
 
 type openStackClient struct {
 	cloud *clientconfig.Cloud
+	// Guards the fields below. The client is used by the share type poll
+	// and syncs of the controller at the same time.
+	lock sync.Mutex
+	// Authenticated provider and service clients, created on first use.
+	provider      *gophercloud.ProviderClient
+	shareClient   *gophercloud.ServiceClient
+	networkClient *gophercloud.ServiceClient
+	// Manila API capabilities, detected together with the share client.
+	apiCapabilities *apiCapabilities
+	// Authentications of a client that only validates credentials are not
+	// recorded in keystone_auth metrics.
+	validationOnly bool
 }
 
 func NewOpenStackClient(cloudConfigFilename string) (*openStackClient, error) {
 	cloud, err := getCloudFromFile(cloudConfigFilename)
 	if err != nil {
-		return nil, err
+		return nil, newOpenStackError(reasonAuthFailed, err)
+	}
+	return &openStackClient{
+		cloud: cloud,
+	}, nil
+}
+
+// newOpenStackClientFromCloudConfig returns a client for clouds.yaml content
+// read from source.
+func newOpenStackClientFromCloudConfig(cloudConfig []byte, source string) (*openStackClient, error) {
+	cloud, err := parseCloudConfig(cloudConfig, source)
+	if err != nil {
+		return nil, newOpenStackError(reasonAuthFailed, err)
 	}
 	return &openStackClient{
 		cloud: cloud,
@@ -34,6 +66,330 @@ func NewOpenStackClient(cloudConfigFilename string) (*openStackClient, error) {
 }
 
 func (o *openStackClient) GetShareTypes() ([]sharetypes.ShareType, error) {
+	client, err := o.getShareClient()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	allPages, err := sharetypes.List(client, &sharetypes.ListOpts{}).AllPages(context.TODO())
+	observeManilaRequest("ListShareTypes", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list available share types: %w", err)
+	}
+
+	return sharetypes.ExtractShareTypes(allPages)
+}
+
+// GetDefaultShareType returns the default share type of Manila or nil, if
+// Manila has no default share type configured.
+func (o *openStackClient) GetDefaultShareType() (*sharetypes.ShareType, error) {
+	client, err := o.getShareClient()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	shareType, err := sharetypes.GetDefault(context.TODO(), client).Extract()
+	if gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+		// Not an error, there is no default share type.
+		observeManilaRequest("GetDefaultShareType", start, nil)
+		return nil, nil
+	}
+	observeManilaRequest("GetDefaultShareType", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get default share type: %w", err)
+	}
+	return shareType, nil
+}
+
+// absoluteLimits are Manila absolute limits of the project, -1 is unlimited.
+type absoluteLimits struct {
+	MaxTotalShares          int `json:"maxTotalShares"`
+	TotalSharesUsed         int `json:"totalSharesUsed"`
+	MaxTotalShareGigabytes  int `json:"maxTotalShareGigabytes"`
+	TotalShareGigabytesUsed int `json:"totalShareGigabytesUsed"`
+}
+
+// quotaDetail is a quota of a resource with its usage, -1 limit is unlimited.
+type quotaDetail struct {
+	InUse    int `json:"in_use"`
+	Limit    int `json:"limit"`
+	Reserved int `json:"reserved"`
+}
+
+// shareTypeQuotaSet is a quota set of a share type with usage details.
+type shareTypeQuotaSet struct {
+	Shares    quotaDetail `json:"shares"`
+	Gigabytes quotaDetail `json:"gigabytes"`
+}
+
+// GetAbsoluteLimits returns absolute limits of the project. gophercloud has no
+// Manila limits API, the share client is used directly.
+func (o *openStackClient) GetAbsoluteLimits() (*absoluteLimits, error) {
+	client, err := o.getShareClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		Limits struct {
+			Absolute absoluteLimits `json:"absolute"`
+		} `json:"limits"`
+	}
+	start := time.Now()
+	_, err = client.Get(context.TODO(), client.ServiceURL("limits"), &body, nil)
+	observeManilaRequest("GetLimits", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get limits: %w", err)
+	}
+	return &body.Limits.Absolute, nil
+}
+
+// GetShareTypeQuotaSet returns quotas and their usage of a share type in the
+// project. gophercloud has no Manila quota sets API, the share client is used
+// directly.
+func (o *openStackClient) GetShareTypeQuotaSet(shareTypeName string) (*shareTypeQuotaSet, error) {
+	client, err := o.getShareClient()
+	if err != nil {
+		return nil, err
+	}
+	projectID, err := o.getProjectID()
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		QuotaSet shareTypeQuotaSet `json:"quota_set"`
+	}
+	quotaURL := client.ServiceURL("quota-sets", projectID, "detail") + "?share_type=" + url.QueryEscape(shareTypeName)
+	start := time.Now()
+	_, err = client.Get(context.TODO(), quotaURL, &body, nil)
+	observeManilaRequest("GetShareTypeQuotaSet", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get quotas of share type %s: %w", shareTypeName, err)
+	}
+	return &body.QuotaSet, nil
+}
+
+// getProjectID returns ID of the project the client is authenticated to.
+func (o *openStackClient) getProjectID() (string, error) {
+	provider, err := o.getProvider()
+	if err != nil {
+		return "", err
+	}
+	result, ok := provider.GetAuthResult().(tokens.CreateResult)
+	if !ok {
+		return "", fmt.Errorf("cannot get project ID: unsupported Identity API version")
+	}
+	project, err := result.ExtractProject()
+	if err != nil {
+		return "", fmt.Errorf("cannot get project ID: %w", err)
+	}
+	if project == nil {
+		return "", fmt.Errorf("cannot get project ID: the token is not scoped to a project")
+	}
+	return project.ID, nil
+}
+
+// FindPortByIP returns Neutron port with the given fixed IP address or nil,
+// if there is no such port.
+func (o *openStackClient) FindPortByIP(ip string) (*ports.Port, error) {
+	client, err := o.getNetworkClient()
+	if err != nil {
+		return nil, err
+	}
+
+	allPages, err := ports.List(client, ports.ListOpts{
+		FixedIPs: []ports.FixedIPOpts{{IPAddress: ip}},
+	}).AllPages(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("cannot list ports: %w", err)
+	}
+	allPorts, err := ports.ExtractPorts(allPages)
+	if err != nil {
+		return nil, err
+	}
+	if len(allPorts) == 0 {
+		return nil, nil
+	}
+	return &allPorts[0], nil
+}
+
+// GetShareNetworks returns share networks of the given Neutron network and subnet.
+func (o *openStackClient) GetShareNetworks(neutronNetID, neutronSubnetID string) ([]sharenetworks.ShareNetwork, error) {
+	client, err := o.getShareClient()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	allPages, err := sharenetworks.ListDetail(client, sharenetworks.ListOpts{
+		NeutronNetID:    neutronNetID,
+		NeutronSubnetID: neutronSubnetID,
+	}).AllPages(context.TODO())
+	observeManilaRequest("ListShareNetworks", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list share networks: %w", err)
+	}
+	return sharenetworks.ExtractShareNetworks(allPages)
+}
+
+func (o *openStackClient) CreateShareNetwork(opts sharenetworks.CreateOpts) (*sharenetworks.ShareNetwork, error) {
+	client, err := o.getShareClient()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	shareNetwork, err := sharenetworks.Create(context.TODO(), client, opts).Extract()
+	observeManilaRequest("CreateShareNetwork", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create share network: %w", err)
+	}
+	return shareNetwork, nil
+}
+
+// GetShares returns all shares of the project.
+func (o *openStackClient) GetShares() ([]shares.Share, error) {
+	client, err := o.getShareClient()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	allPages, err := shares.ListDetail(client, shares.ListOpts{}).AllPages(context.TODO())
+	observeManilaRequest("ListShares", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list shares: %w", err)
+	}
+	return shares.ExtractShares(allPages)
+}
+
+// DeleteShare deletes the share. Missing share is not an error.
+func (o *openStackClient) DeleteShare(id string) error {
+	client, err := o.getShareClient()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = shares.Delete(context.TODO(), client, id).ExtractErr()
+	if gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+		err = nil
+	}
+	observeManilaRequest("DeleteShare", start, err)
+	if err != nil {
+		return fmt.Errorf("cannot delete share %s: %w", id, err)
+	}
+	return nil
+}
+
+// GetAPICapabilities returns microversions and capabilities of Manila API.
+// They're detected when the share client is created, this retries a failed
+// detection.
+func (o *openStackClient) GetAPICapabilities() (*apiCapabilities, error) {
+	client, err := o.getShareClient()
+	if err != nil {
+		return nil, err
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.apiCapabilities != nil {
+		return o.apiCapabilities, nil
+	}
+	caps, err := detectAPICapabilities(client)
+	if err != nil {
+		return nil, err
+	}
+	// The current share client may be in use, replace it with a copy that
+	// uses the negotiated microversion.
+	negotiated := *o.shareClient
+	negotiated.Microversion = caps.version.String()
+	o.shareClient = &negotiated
+	o.apiCapabilities = caps
+	return caps, nil
+}
+
+// detectAPICapabilities detects microversions supported by Manila. The
+// highest one the operator uses must be set in the share client, without it,
+// the client uses the minimum microversion.
+func detectAPICapabilities(client *gophercloud.ServiceClient) (*apiCapabilities, error) {
+	start := time.Now()
+	apiVersion, err := apiversions.Get(context.TODO(), client, "v2").Extract()
+	observeManilaRequest("GetAPIVersion", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get Shared File Systems API v2 version: %w", err)
+	}
+	return newAPICapabilities(apiVersion.MinVersion, apiVersion.Version)
+}
+
+func (o *openStackClient) getShareClient() (*gophercloud.ServiceClient, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.shareClient != nil {
+		return o.shareClient, nil
+	}
+
+	provider, err := o.getProviderLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := openstack.NewSharedFileSystemV2(provider, gophercloud.EndpointOpts{
+		Region: o.cloud.RegionName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot find an endpoint for Shared File Systems API v2: %w", err)
+	}
+	caps, err := detectAPICapabilities(client)
+	if err != nil {
+		klog.Warningf("Unable to negotiate Manila API microversion: %v", err)
+	} else {
+		client.Microversion = caps.version.String()
+		o.apiCapabilities = caps
+	}
+
+	o.shareClient = client
+	return client, nil
+}
+
+func (o *openStackClient) getNetworkClient() (*gophercloud.ServiceClient, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.networkClient != nil {
+		return o.networkClient, nil
+	}
+
+	provider, err := o.getProviderLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := openstack.NewNetworkV2(provider, gophercloud.EndpointOpts{
+		Region: o.cloud.RegionName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot find an endpoint for Networking API v2: %w", err)
+	}
+
+	o.networkClient = client
+	return client, nil
+}
+
+func (o *openStackClient) getProvider() (*gophercloud.ProviderClient, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.getProviderLocked()
+}
+
+// getProviderLocked returns the authenticated provider, o.lock must be held.
+func (o *openStackClient) getProviderLocked() (*gophercloud.ProviderClient, error) {
+	if o.provider != nil {
+		return o.provider, nil
+	}
+
 	clientOpts := new(clientconfig.ClientOpts)
 
 	if o.cloud.AuthInfo != nil {
@@ -45,12 +401,15 @@ func (o *openStackClient) GetShareTypes() ([]sharetypes.ShareType, error) {
 
 	opts, err := clientconfig.AuthOptions(clientOpts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate auth options: %w", err)
+		return nil, newOpenStackError(reasonAuthFailed, fmt.Errorf("failed to generate auth options: %w", err))
 	}
+	// The provider is reused across syncs, let gophercloud get a new token
+	// when the current one expires.
+	opts.AllowReauth = true
 
 	provider, err := openstack.NewClient(opts.IdentityEndpoint)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create a provider client: %w", err)
+		return nil, newOpenStackError(reasonAuthFailed, fmt.Errorf("failed to create a provider client: %w", err))
 	}
 
 	// we represent version using commits since we don't tag releases
@@ -60,15 +419,17 @@ func (o *openStackClient) GetShareTypes() ([]sharetypes.ShareType, error) {
 
 	cert, err := getCloudProviderCert()
 	if err != nil && !os.IsNotExist(err) {
-		return nil, fmt.Errorf("failed to get cloud provider CA certificate: %w", err)
+		return nil, newOpenStackError(reasonTLSError, fmt.Errorf("failed to get cloud provider CA certificate: %w", err))
 	}
 
 	if len(cert) > 0 {
 		certPool, err := x509.SystemCertPool()
 		if err != nil {
-			return nil, fmt.Errorf("create system cert pool failed: %w", err)
+			return nil, newOpenStackError(reasonTLSError, fmt.Errorf("create system cert pool failed: %w", err))
+		}
+		if !certPool.AppendCertsFromPEM(cert) {
+			return nil, newOpenStackError(reasonTLSError, fmt.Errorf("no valid certificate found in %s", util.CertFile))
 		}
-		certPool.AppendCertsFromPEM(cert)
 		client := http.Client{
 			Transport: &http.Transport{
 				Proxy: http.ProxyFromEnvironment,
@@ -83,43 +444,75 @@ func (o *openStackClient) GetShareTypes() ([]sharetypes.ShareType, error) {
 	provider.HTTPClient.Timeout = 120 * time.Second
 
 	err = openstack.Authenticate(context.TODO(), provider, *opts)
+	if !o.validationOnly {
+		observeKeystoneAuth(err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("cannot authenticate with given credentials: %w", err)
 	}
-
-	client, err := openstack.NewSharedFileSystemV2(provider, gophercloud.EndpointOpts{
-		Region: clientOpts.RegionName,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("cannot find an endpoint for Shared File Systems API v2: %w", err)
+	// Count re-authentications of expired tokens too.
+	if reauth := provider.ReauthFunc; reauth != nil && !o.validationOnly {
+		provider.ReauthFunc = func(ctx context.Context) error {
+			err := reauth(ctx)
+			observeKeystoneAuth(err)
+			return err
+		}
 	}
 
-	allPages, err := sharetypes.List(client, &sharetypes.ListOpts{}).AllPages(context.TODO())
+	o.provider = provider
+	return provider, nil
+}
+
+// ValidateCredentials authenticates against Keystone with clouds.yaml
+// content read from source. It returns true when the credentials are
+// rejected, i.e. they are invalid or Keystone refuses them. Other errors,
+// e.g. unreachable Keystone, do not say anything about the credentials.
+func ValidateCredentials(cloudConfig []byte, source string) (bool, error) {
+	client, err := newOpenStackClientFromCloudConfig(cloudConfig, source)
 	if err != nil {
-		return nil, fmt.Errorf("cannot list available share types: %w", err)
+		return true, err
 	}
-
-	return sharetypes.ExtractShareTypes(allPages)
+	client.validationOnly = true
+	_, err = client.getProvider()
+	return classifyOpenStackError(err) == reasonAuthFailed, err
 }
 
 func getCloudFromFile(filename string) (*clientconfig.Cloud, error) {
-	cloudConfig, err := ioutil.ReadFile(filename)
+	cloudConfig, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
+	return parseCloudConfig(cloudConfig, filename)
+}
+
+// parseCloudConfig returns the cluster cloud from clouds.yaml content read
+// from source.
+func parseCloudConfig(cloudConfig []byte, source string) (*clientconfig.Cloud, error) {
 	var clouds clientconfig.Clouds
-	err = yaml.Unmarshal(cloudConfig, &clouds)
+	err := yaml.Unmarshal(cloudConfig, &clouds)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal clouds credentials from %s: %w", filename, err)
+		return nil, fmt.Errorf("failed to unmarshal clouds credentials from %s: %w", source, err)
 	}
 
 	cfg, ok := clouds.Clouds[util.CloudName]
 	if !ok {
-		return nil, fmt.Errorf("could not find cloud named %q in credential file %s", util.CloudName, filename)
+		return nil, fmt.Errorf("could not find cloud named %q in credentials from %s", util.CloudName, source)
 	}
 	return &cfg, nil
 }
 
 func getCloudProviderCert() ([]byte, error) {
-	return ioutil.ReadFile(util.CertFile)
+	return os.ReadFile(util.CertFile)
+}
+
+// hashContent returns hash of the contents. Missing (nil) content is hashed
+// as an empty one.
+func hashContent(contents ...[]byte) string {
+	hash := sha256.New()
+	for _, content := range contents {
+		// Length prefix keeps the contents apart.
+		fmt.Fprintf(hash, "%d:", len(content))
+		hash.Write(content)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
 }
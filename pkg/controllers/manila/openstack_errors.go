@@ -0,0 +1,89 @@
+package manila
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/gophercloud/gophercloud/v2"
+)
+
+// Reasons of conditions reporting OpenStack errors.
+const (
+	// Credentials are missing, invalid or rejected by Keystone.
+	reasonAuthFailed = "AuthFailed"
+	// Manila (sharev2) is not in the service catalog.
+	reasonEndpointNotFound = "EndpointNotFound"
+	// CA bundle is missing or invalid, or the server certificate is not
+	// trusted.
+	reasonTLSError = "TLSError"
+	// OpenStack API can't be reached or it's temporarily unavailable.
+	reasonUnreachable = "Unreachable"
+	// Manila does not provide any share types.
+	reasonNoShareTypes = "NoShareTypes"
+	// Any other error.
+	reasonOpenStackError = "OpenStackError"
+)
+
+// openStackError is an error with a known reason. It's used for errors that
+// can't be classified by their type, e.g. failure to read the CA bundle.
+type openStackError struct {
+	reason string
+	err    error
+}
+
+func (e *openStackError) Error() string {
+	return e.err.Error()
+}
+
+func (e *openStackError) Unwrap() error {
+	return e.err
+}
+
+func newOpenStackError(reason string, err error) error {
+	return &openStackError{reason: reason, err: err}
+}
+
+// classifyOpenStackError returns condition reason of an error returned by
+// openStackClient.
+func classifyOpenStackError(err error) string {
+	var osErr *openStackError
+	var endpointErr *gophercloud.ErrEndpointNotFound
+	var serviceErr *gophercloud.ErrServiceNotFound
+	var reauthErr *gophercloud.ErrUnableToReauthenticate
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certInvalidErr x509.CertificateInvalidError
+	var recordHeaderErr tls.RecordHeaderError
+	var netErr net.Error
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &osErr):
+		return osErr.reason
+	case errors.As(err, &endpointErr), errors.As(err, &serviceErr):
+		return reasonEndpointNotFound
+	case errors.As(err, &reauthErr),
+		gophercloud.ResponseCodeIs(err, http.StatusUnauthorized),
+		gophercloud.ResponseCodeIs(err, http.StatusForbidden):
+		return reasonAuthFailed
+	// TLS errors are net.Errors too, check them first.
+	case errors.As(err, &certErr),
+		errors.As(err, &unknownAuthorityErr),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &certInvalidErr),
+		errors.As(err, &recordHeaderErr):
+		return reasonTLSError
+	case errors.As(err, &netErr),
+		gophercloud.ResponseCodeIs(err, http.StatusBadGateway),
+		gophercloud.ResponseCodeIs(err, http.StatusServiceUnavailable),
+		gophercloud.ResponseCodeIs(err, http.StatusGatewayTimeout):
+		return reasonUnreachable
+	default:
+		return reasonOpenStackError
+	}
+}
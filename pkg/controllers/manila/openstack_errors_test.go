@@ -0,0 +1,68 @@
+package manila
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2"
+)
+
+func TestClassifyOpenStackError(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{
+			name:     "no error",
+			expected: "",
+		},
+		{
+			name:     "missing clouds.yaml",
+			err:      fmt.Errorf("unable to connect to OpenStack: %w", newOpenStackError(reasonAuthFailed, os.ErrNotExist)),
+			expected: reasonAuthFailed,
+		},
+		{
+			name:     "invalid password",
+			err:      fmt.Errorf("cannot authenticate with given credentials: %w", gophercloud.ErrUnexpectedResponseCode{Actual: 401}),
+			expected: reasonAuthFailed,
+		},
+		{
+			name:     "missing sharev2 endpoint",
+			err:      fmt.Errorf("cannot find an endpoint for Shared File Systems API v2: %w", &gophercloud.ErrEndpointNotFound{}),
+			expected: reasonEndpointNotFound,
+		},
+		{
+			name:     "untrusted certificate",
+			err:      &url.Error{Op: "Post", URL: "https://keystone", Err: x509.UnknownAuthorityError{}},
+			expected: reasonTLSError,
+		},
+		{
+			name:     "connection refused",
+			err:      &url.Error{Op: "Post", URL: "https://keystone", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}},
+			expected: reasonUnreachable,
+		},
+		{
+			name:     "service unavailable",
+			err:      fmt.Errorf("cannot list available share types: %w", gophercloud.ErrUnexpectedResponseCode{Actual: 503}),
+			expected: reasonUnreachable,
+		},
+		{
+			name:     "other error",
+			err:      errors.New("boom"),
+			expected: reasonOpenStackError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reason := classifyOpenStackError(tc.err)
+			if reason != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package manila
+
+import (
+	"testing"
+
+	"github.com/openshift/csi-driver-manila-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestHashContent(t *testing.T) {
+	noCA := hashContent([]byte("clouds: {}"), nil)
+	if hashContent([]byte("clouds: {}"), nil) != noCA {
+		t.Errorf("hash of unchanged content changed")
+	}
+
+	if hashContent([]byte("clouds: {}"), []byte{}) != noCA {
+		t.Errorf("empty CA bundle should hash as a missing one")
+	}
+
+	withCA := hashContent([]byte("clouds: {}"), []byte("cert"))
+	if withCA == noCA {
+		t.Errorf("hash did not change with CA bundle")
+	}
+
+	// Moving content between the inputs changes the hash.
+	if hashContent([]byte("clouds: {}cert"), nil) == withCA {
+		t.Errorf("hash did not change when content moved between inputs")
+	}
+}
+
+func TestCloudConfigChanged(t *testing.T) {
+	secretIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	// Credentials published by SecretSyncController, i.e. not rejected
+	// by Keystone.
+	publishCredentials := func(password string) {
+		secretIndexer.Update(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: util.OperandNamespace, Name: util.ManilaSecretName},
+			Data: map[string][]byte{
+				"os-authURL":  []byte("https://keystone:5000"),
+				"os-userName": []byte("manila"),
+				"os-password": []byte(password),
+			},
+		})
+	}
+	c := &ManilaController{secretLister: corelisters.NewSecretLister(secretIndexer)}
+
+	publishCredentials("old")
+	if c.cloudConfigChanged() {
+		t.Errorf("expected no change without OpenStack client")
+	}
+	client, err := c.getOpenStackClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.cloud.AuthInfo.Password != "old" || client.cloud.AuthInfo.Username != "manila" || client.cloud.AuthInfo.AuthURL != "https://keystone:5000" {
+		t.Errorf("expected client created from the driver secret, got %+v", client.cloud.AuthInfo)
+	}
+	if c.cloudConfigChanged() {
+		t.Errorf("expected no change with the same credentials")
+	}
+
+	publishCredentials("new")
+	if !c.cloudConfigChanged() {
+		t.Errorf("expected change after credential rotation")
+	}
+	client, err = c.getOpenStackClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.cloud.AuthInfo.Password != "new" {
+		t.Errorf("expected client with rotated credentials, got password %q", client.cloud.AuthInfo.Password)
+	}
+	if c.cloudConfigChanged() {
+		t.Errorf("expected no change after the client was rebuilt")
+	}
+}
@@ -0,0 +1,187 @@
+package manila
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/shares"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Condition reporting shares of the cluster without PVs and PVs without
+	// shares.
+	orphanedResourcesCondition = operatorConditionPrefix + "OrphanedResources"
+
+	// Shares younger than this are never orphans, their PV may not be
+	// created yet. PVs created less than this before the shares were listed
+	// are never dangling, their share may be missing in the list.
+	orphanGracePeriod = time.Hour
+	// Maximum number of shares and PVs listed in the condition message.
+	maxReportedOrphans = 10
+)
+
+// Share statuses in which the share is not checked.
+var transientShareStatuses = sets.New("creating", "deleting", "creating_from_snapshot")
+
+// findOrphans returns shares tagged with the cluster infrastructureName that
+// have no PV and Manila PVs whose share does not exist. listed is the time the
+// shares were listed.
+func findOrphans(allShares []shares.Share, listed time.Time, pvs []*corev1.PersistentVolume, infraName string) ([]shares.Share, []*corev1.PersistentVolume) {
+	drivers := sets.New[string]()
+	for _, driver := range shareProtocolDrivers {
+		drivers.Insert(driver)
+	}
+	pvShareIDs := sets.New[string]()
+	var manilaPVs []*corev1.PersistentVolume
+	for _, pv := range pvs {
+		if pv.Spec.CSI == nil || !drivers.Has(pv.Spec.CSI.Driver) {
+			continue
+		}
+		pvShareIDs.Insert(pv.Spec.CSI.VolumeHandle)
+		manilaPVs = append(manilaPVs, pv)
+	}
+
+	shareIDs := sets.New[string]()
+	var orphans []shares.Share
+	for _, share := range allShares {
+		shareIDs.Insert(share.ID)
+		if infraName == "" || share.Metadata[shareMetadataInfrastructureName] != infraName {
+			continue
+		}
+		if transientShareStatuses.Has(share.Status) || now().Sub(share.CreatedAt) < orphanGracePeriod {
+			continue
+		}
+		if !pvShareIDs.Has(share.ID) {
+			orphans = append(orphans, share)
+		}
+	}
+
+	var dangling []*corev1.PersistentVolume
+	for _, pv := range manilaPVs {
+		// Released PVs are being reclaimed, their share may be already gone.
+		if pv.DeletionTimestamp != nil || pv.Status.Phase == corev1.VolumeReleased {
+			continue
+		}
+		if listed.Sub(pv.CreationTimestamp.Time) < orphanGracePeriod {
+			continue
+		}
+		if !shareIDs.Has(pv.Spec.CSI.VolumeHandle) {
+			dangling = append(dangling, pv)
+		}
+	}
+	return orphans, dangling
+}
+
+// syncOrphans reports orphaned shares and dangling PVs in metrics and a
+// condition and deletes orphaned shares older than deleteAfter, if set. When
+// the snapshot has no shares, they could not be listed and the previous report
+// is kept.
+func (c *ManilaController) syncOrphans(ctx context.Context, snapshot *shareTypeSnapshot, deleteAfter time.Duration) error {
+	allShares := snapshot.shares
+	if allShares == nil {
+		return nil
+	}
+	c.pruneDeletedOrphanIDs(allShares)
+	var infraName string
+	if infra, err := c.infraLister.Get(infrastructureName); err == nil {
+		infraName = infra.Status.InfrastructureName
+	}
+	pvs, err := c.pvLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	orphans, dangling := findOrphans(allShares, snapshot.fetched, pvs, infraName)
+	orphanedSharesGauge.Set(float64(len(orphans)))
+	danglingPVsGauge.Set(float64(len(dangling)))
+
+	if deleteAfter > 0 {
+		orphans = c.deleteOrphans(orphans, deleteAfter)
+	}
+
+	var msgs []string
+	if len(orphans) > 0 {
+		var names []string
+		for _, share := range orphans {
+			names = append(names, fmt.Sprintf("%s (%s)", share.ID, share.Name))
+		}
+		msgs = append(msgs, "Manila shares of the cluster without PersistentVolume: "+truncatedList(names))
+	}
+	if len(dangling) > 0 {
+		var names []string
+		for _, pv := range dangling {
+			names = append(names, fmt.Sprintf("%s (share %s)", pv.Name, pv.Spec.CSI.VolumeHandle))
+		}
+		msgs = append(msgs, "PersistentVolumes without Manila share: "+truncatedList(names))
+	}
+	msg := strings.Join(msgs, "; ")
+	if msg != "" {
+		klog.V(2).Info(msg)
+	}
+	return c.updateCondition(ctx, orphanedResourcesCondition, "OrphansFound", msg)
+}
+
+// deleteOrphans deletes orphaned shares created more than deleteAfter ago and
+// returns the orphans that were not deleted.
+func (c *ManilaController) deleteOrphans(orphans []shares.Share, deleteAfter time.Duration) []shares.Share {
+	if c.deletedOrphanIDs == nil {
+		c.deletedOrphanIDs = sets.New[string]()
+	}
+	var toDelete, kept []shares.Share
+	for _, share := range orphans {
+		if c.deletedOrphanIDs.Has(share.ID) {
+			continue
+		}
+		if now().Sub(share.CreatedAt) >= deleteAfter {
+			toDelete = append(toDelete, share)
+		} else {
+			kept = append(kept, share)
+		}
+	}
+	if len(toDelete) == 0 {
+		return kept
+	}
+
+	openstackClient, err := c.getOpenStackClient()
+	if err != nil {
+		klog.Warningf("Unable to delete orphaned shares: %v", err)
+		return orphans
+	}
+	for _, share := range toDelete {
+		if err := openstackClient.DeleteShare(share.ID); err != nil {
+			c.eventRecorder.Warningf("OrphanedShareDeleteFailed", "Failed to delete orphaned Manila share %s (%s): %v", share.ID, share.Name, err)
+			kept = append(kept, share)
+			continue
+		}
+		c.deletedOrphanIDs.Insert(share.ID)
+		c.eventRecorder.Eventf("OrphanedShareDeleted", "Deleted Manila share %s (%s) created at %s, it has no PersistentVolume", share.ID, share.Name, share.CreatedAt.Format(time.RFC3339))
+	}
+	// Get the shares without the deleted ones.
+	c.shareTypeCache.refresh()
+	return kept
+}
+
+// pruneDeletedOrphanIDs forgets deleted orphans that are not listed anymore.
+func (c *ManilaController) pruneDeletedOrphanIDs(allShares []shares.Share) {
+	if c.deletedOrphanIDs.Len() == 0 {
+		return
+	}
+	listed := sets.New[string]()
+	for _, share := range allShares {
+		listed.Insert(share.ID)
+	}
+	c.deletedOrphanIDs = c.deletedOrphanIDs.Intersection(listed)
+}
+
+// truncatedList joins at most maxReportedOrphans items.
+func truncatedList(items []string) string {
+	if len(items) <= maxReportedOrphans {
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(items[:maxReportedOrphans], ", "), len(items)-maxReportedOrphans)
+}
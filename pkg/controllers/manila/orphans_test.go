@@ -0,0 +1,91 @@
+package manila
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/shares"
+	"github.com/openshift/csi-driver-manila-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestFindOrphans(t *testing.T) {
+	fakeNow := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return fakeNow }
+	defer func() { now = time.Now }()
+
+	share := func(id, infraName, status string, age time.Duration) shares.Share {
+		s := shares.Share{ID: id, Status: status, CreatedAt: fakeNow.Add(-age)}
+		if infraName != "" {
+			s.Metadata = map[string]string{shareMetadataInfrastructureName: infraName}
+		}
+		return s
+	}
+	pv := func(name, driver, shareID string, phase corev1.PersistentVolumePhase, age time.Duration) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(fakeNow.Add(-age))},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeSource: corev1.PersistentVolumeSource{
+					CSI: &corev1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: shareID},
+				},
+			},
+			Status: corev1.PersistentVolumeStatus{Phase: phase},
+		}
+	}
+
+	allShares := []shares.Share{
+		share("bound", "mycluster", "available", 48*time.Hour),
+		share("cephfs-bound", "mycluster", "available", 48*time.Hour),
+		share("orphan", "mycluster", "available", 48*time.Hour),
+		share("orphan-error", "mycluster", "error", 2*time.Hour),
+		share("new", "mycluster", "available", 10*time.Minute),
+		share("deleting", "mycluster", "deleting", 48*time.Hour),
+		share("other-cluster", "othercluster", "available", 48*time.Hour),
+		share("untagged", "", "available", 48*time.Hour),
+	}
+	pvs := []*corev1.PersistentVolume{
+		pv("pv-bound", util.ManilaDriverName, "bound", corev1.VolumeBound, 48*time.Hour),
+		pv("pv-cephfs", util.ManilaCephFSDriverName, "cephfs-bound", corev1.VolumeBound, 48*time.Hour),
+		pv("pv-untagged", util.ManilaDriverName, "untagged", corev1.VolumeBound, 48*time.Hour),
+		pv("pv-dangling", util.ManilaDriverName, "missing", corev1.VolumeBound, 48*time.Hour),
+		pv("pv-released", util.ManilaDriverName, "deleted", corev1.VolumeReleased, 48*time.Hour),
+		pv("pv-cinder", "cinder.csi.openstack.org", "missing", corev1.VolumeBound, 48*time.Hour),
+		// Provisioned after the shares were listed.
+		pv("pv-fresh", util.ManilaDriverName, "not-listed-yet", corev1.VolumeBound, -time.Minute),
+		pv("pv-recent", util.ManilaDriverName, "not-listed-yet", corev1.VolumeBound, 10*time.Minute),
+	}
+
+	orphans, dangling := findOrphans(allShares, fakeNow, pvs, "mycluster")
+	var orphanIDs []string
+	for _, share := range orphans {
+		orphanIDs = append(orphanIDs, share.ID)
+	}
+	if len(orphanIDs) != 2 || orphanIDs[0] != "orphan" || orphanIDs[1] != "orphan-error" {
+		t.Errorf("expected orphans [orphan orphan-error], got %v", orphanIDs)
+	}
+	if len(dangling) != 1 || dangling[0].Name != "pv-dangling" {
+		t.Errorf("expected dangling PV pv-dangling, got %v", dangling)
+	}
+
+	orphans, _ = findOrphans(allShares, fakeNow, pvs, "")
+	if len(orphans) != 0 {
+		t.Errorf("expected no orphans without infrastructure name, got %v", orphans)
+	}
+}
+
+func TestPruneDeletedOrphanIDs(t *testing.T) {
+	c := newTestController()
+	c.deletedOrphanIDs = sets.New("deleting", "gone")
+
+	c.pruneDeletedOrphanIDs([]shares.Share{{ID: "deleting"}, {ID: "other"}})
+	if !c.deletedOrphanIDs.Equal(sets.New("deleting")) {
+		t.Errorf("expected deleted orphans [deleting], got %v", sets.List(c.deletedOrphanIDs))
+	}
+
+	c.pruneDeletedOrphanIDs([]shares.Share{{ID: "other"}})
+	if c.deletedOrphanIDs.Len() != 0 {
+		t.Errorf("expected no deleted orphans, got %v", sets.List(c.deletedOrphanIDs))
+	}
+}
@@ -0,0 +1,90 @@
+package manila
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
+	"github.com/openshift/csi-driver-manila-operator/pkg/util"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Manila share protocols supported by the operator. Each protocol is served
+// by its own instance of the CSI driver.
+const (
+	shareProtocolNFS    = "NFS"
+	shareProtocolCephFS = "CEPHFS"
+)
+
+// supportedShareProtocols are the supported protocols in order of preference.
+var supportedShareProtocols = []string{shareProtocolNFS, shareProtocolCephFS}
+
+// shareProtocolDrivers are names of the CSI driver instances of the protocols.
+var shareProtocolDrivers = map[string]string{
+	shareProtocolNFS:    util.ManilaDriverName,
+	shareProtocolCephFS: util.ManilaCephFSDriverName,
+}
+
+// getShareTypeProtocols returns supported protocols of the share type, as
+// found in its storage_protocol extra spec, e.g. "NFS_CIFS" or "<in> CEPHFS".
+// Share types without the extra spec are NFS ones.
+func getShareTypeProtocols(shareType sharetypes.ShareType) []string {
+	value, ok := getExtraSpec(shareType, extraSpecStorageProtocol)
+	if !ok {
+		return []string{shareProtocolNFS}
+	}
+	tokens := sets.New(strings.FieldsFunc(strings.ToUpper(value), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})...)
+	var protocols []string
+	for _, protocol := range supportedShareProtocols {
+		if tokens.Has(protocol) {
+			protocols = append(protocols, protocol)
+		}
+	}
+	return protocols
+}
+
+// getShareProtocols returns protocols supported by any of the share types.
+func getShareProtocols(shareTypes []sharetypes.ShareType) sets.Set[string] {
+	protocols := sets.New[string]()
+	for _, shareType := range shareTypes {
+		protocols.Insert(getShareTypeProtocols(shareType)...)
+	}
+	return protocols
+}
+
+// protocolShareType returns the share type as seen by StorageClass naming.
+// NFS StorageClasses are named after the share type, as they were before
+// other protocols were supported. StorageClasses of other protocols get the
+// protocol as a suffix, e.g. csi-manila-gold-cephfs.
+func protocolShareType(shareType sharetypes.ShareType, protocol string) sharetypes.ShareType {
+	if protocol == shareProtocolNFS {
+		return shareType
+	}
+	suffix := "-" + strings.ToLower(protocol)
+	return sharetypes.ShareType{
+		ID:   shareType.ID + suffix,
+		Name: shareType.Name + suffix,
+	}
+}
+
+// storageClassKey returns key of a generated StorageClass in
+// storageClassNames, i.e. ID of its protocolShareType.
+func storageClassKey(shareTypeID, protocol string) string {
+	if protocol == "" {
+		protocol = shareProtocolNFS
+	}
+	return protocolShareType(sharetypes.ShareType{ID: shareTypeID}, protocol).ID
+}
+
+// isManilaDriver returns true for names of all CSI driver instances managed
+// by the operator.
+func isManilaDriver(driverName string) bool {
+	for _, name := range shareProtocolDrivers {
+		if name == driverName {
+			return true
+		}
+	}
+	return false
+}
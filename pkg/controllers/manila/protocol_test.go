@@ -0,0 +1,61 @@
+package manila
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
+)
+
+func TestGetShareTypeProtocols(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		extraSpecs map[string]any
+		expected   []string
+	}{
+		{
+			name:     "missing extra spec",
+			expected: []string{shareProtocolNFS},
+		},
+		{
+			name:       "NFS",
+			extraSpecs: map[string]any{"storage_protocol": "NFS"},
+			expected:   []string{shareProtocolNFS},
+		},
+		{
+			name:       "NFS_CIFS",
+			extraSpecs: map[string]any{"storage_protocol": "NFS_CIFS"},
+			expected:   []string{shareProtocolNFS},
+		},
+		{
+			name:       "in operator",
+			extraSpecs: map[string]any{"storage_protocol": "<in> CEPHFS"},
+			expected:   []string{shareProtocolCephFS},
+		},
+		{
+			name:       "lower case",
+			extraSpecs: map[string]any{"storage_protocol": "cephfs"},
+			expected:   []string{shareProtocolCephFS},
+		},
+		{
+			name:       "preferred protocol first",
+			extraSpecs: map[string]any{"storage_protocol": "<or> CEPHFS <or> NFS"},
+			expected:   []string{shareProtocolNFS, shareProtocolCephFS},
+		},
+		{
+			name:       "unsupported only",
+			extraSpecs: map[string]any{"storage_protocol": "CIFS_GLUSTERFS"},
+		},
+		{
+			name:       "substring of supported protocol",
+			extraSpecs: map[string]any{"storage_protocol": "NFSX"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			protocols := getShareTypeProtocols(sharetypes.ShareType{Name: "gold", ExtraSpecs: tc.extraSpecs})
+			if !reflect.DeepEqual(protocols, tc.expected) {
+				t.Errorf("expected protocols %v, got %v", tc.expected, protocols)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package manila
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Condition reporting Manila quotas whose usage crossed the threshold.
+	quotaWarningCondition = operatorConditionPrefix + "QuotaWarning"
+	// Default quotaWarningThreshold, in percent of the quota.
+	defaultQuotaWarningThreshold = 90
+
+	quotaResourceShares    = "shares"
+	quotaResourceGigabytes = "gigabytes"
+)
+
+// quotaUsage is a Manila quota of a resource and its usage. Empty shareType
+// is the project quota. -1 limit is unlimited.
+type quotaUsage struct {
+	shareType string
+	resource  string
+	limit     int
+	inUse     int
+}
+
+// fetchQuotas returns quotas of the project and of the share types, as
+// available in Manila API.
+func fetchQuotas(openstackClient *openStackClient, shareTypes []sharetypes.ShareType, api *apiCapabilities) ([]quotaUsage, error) {
+	limits, err := openstackClient.GetAbsoluteLimits()
+	if err != nil {
+		return nil, err
+	}
+	quotas := []quotaUsage{
+		{resource: quotaResourceShares, limit: limits.MaxTotalShares, inUse: limits.TotalSharesUsed},
+		{resource: quotaResourceGigabytes, limit: limits.MaxTotalShareGigabytes, inUse: limits.TotalShareGigabytesUsed},
+	}
+
+	if !api.has(capabilityShareTypeQuotas) {
+		return quotas, nil
+	}
+	for _, shareType := range shareTypes {
+		quotaSet, err := openstackClient.GetShareTypeQuotaSet(shareType.Name)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas,
+			quotaUsage{shareType: shareType.Name, resource: quotaResourceShares, limit: quotaSet.Shares.Limit, inUse: quotaSet.Shares.InUse},
+			quotaUsage{shareType: shareType.Name, resource: quotaResourceGigabytes, limit: quotaSet.Gigabytes.Limit, inUse: quotaSet.Gigabytes.InUse},
+		)
+	}
+	return quotas, nil
+}
+
+// usedPercent returns usage of the quota in percent, -1 for unlimited quota.
+func (q quotaUsage) usedPercent() int {
+	switch {
+	case q.limit < 0:
+		return -1
+	case q.limit == 0:
+		// Nothing can be provisioned.
+		return 100
+	}
+	return q.inUse * 100 / q.limit
+}
+
+func (q quotaUsage) String() string {
+	scope := "project"
+	if q.shareType != "" {
+		scope = "share type " + q.shareType
+	}
+	return fmt.Sprintf("%s of %s: %d of %d used", q.resource, scope, q.inUse, q.limit)
+}
+
+// reportQuotas publishes the quotas as metrics and reports quotas with usage
+// at or above the threshold (in percent) in a condition. Nil quotas could not
+// be fetched, their metrics and condition are removed.
+func (c *ManilaController) reportQuotas(ctx context.Context, quotas []quotaUsage, threshold int) error {
+	quotaLimitGauge.Reset()
+	quotaUsageGauge.Reset()
+
+	var exceeded []string
+	for _, q := range quotas {
+		quotaUsageGauge.WithLabelValues(q.resource, q.shareType).Set(float64(q.inUse))
+		if q.limit < 0 {
+			continue
+		}
+		quotaLimitGauge.WithLabelValues(q.resource, q.shareType).Set(float64(q.limit))
+		if q.usedPercent() >= threshold {
+			exceeded = append(exceeded, q.String())
+		}
+	}
+
+	var msg string
+	if len(exceeded) > 0 {
+		msg = fmt.Sprintf("Manila quota usage is at or above %d%%: %s", threshold, strings.Join(exceeded, "; "))
+		klog.V(2).Info(msg)
+	}
+	return c.updateCondition(ctx, quotaWarningCondition, "QuotaThresholdExceeded", msg)
+}
@@ -0,0 +1,82 @@
+package manila
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReportQuotas(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		quotas          []quotaUsage
+		threshold       int
+		expectCondition bool
+		expectedMessage string
+		expectedLimits  int
+	}{
+		{
+			name: "below threshold",
+			quotas: []quotaUsage{
+				{resource: quotaResourceShares, limit: 50, inUse: 10},
+				{resource: quotaResourceGigabytes, limit: 1000, inUse: 899},
+			},
+			threshold:      90,
+			expectedLimits: 2,
+		},
+		{
+			name: "above threshold",
+			quotas: []quotaUsage{
+				{resource: quotaResourceShares, limit: 50, inUse: 10},
+				{shareType: "gold", resource: quotaResourceGigabytes, limit: 100, inUse: 95},
+			},
+			threshold:       90,
+			expectCondition: true,
+			expectedMessage: "gigabytes of share type gold: 95 of 100 used",
+			expectedLimits:  2,
+		},
+		{
+			name: "unlimited",
+			quotas: []quotaUsage{
+				{resource: quotaResourceShares, limit: -1, inUse: 1000},
+				{shareType: "gold", resource: quotaResourceShares, limit: 0, inUse: 0},
+			},
+			threshold:       90,
+			expectCondition: true,
+			expectedMessage: "shares of share type gold: 0 of 0 used",
+			expectedLimits:  1,
+		},
+		{
+			name:      "unknown quotas",
+			threshold: 90,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestController()
+			c.operatorClient = v1helpers.NewFakeOperatorClient(&operatorv1.OperatorSpec{}, &operatorv1.OperatorStatus{}, nil)
+
+			if err := c.reportQuotas(context.TODO(), tc.quotas, tc.threshold); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, status, _, _ := c.operatorClient.GetOperatorState()
+			cnd := v1helpers.FindOperatorCondition(status.Conditions, quotaWarningCondition)
+			if (cnd != nil) != tc.expectCondition {
+				t.Fatalf("expected condition: %t, got %+v", tc.expectCondition, cnd)
+			}
+			if cnd != nil && !strings.Contains(cnd.Message, tc.expectedMessage) {
+				t.Errorf("expected message with %q, got %q", tc.expectedMessage, cnd.Message)
+			}
+			if count := testutil.CollectAndCount(quotaLimitGauge); count != tc.expectedLimits {
+				t.Errorf("expected %d quota limit metrics, got %d", tc.expectedLimits, count)
+			}
+			if count := testutil.CollectAndCount(quotaUsageGauge); count != len(tc.quotas) {
+				t.Errorf("expected %d quota usage metrics, got %d", len(tc.quotas), count)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package manila
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/openshift/csi-driver-manila-operator/pkg/util"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// StorageClass parameter with comma-separated IP addresses or CIDRs
+	// that get access to NFS shares. The driver allows everyone without it.
+	nfsShareClientParameter = "nfs-shareClient"
+	// Key in install-config ConfigMap with the install-config.yaml.
+	installConfigKey = "install-config"
+
+	// Condition reporting NFS StorageClasses whose shares are accessible
+	// from any address.
+	worldAccessibleCondition = operatorConditionPrefix + "WorldAccessibleStorageClasses"
+)
+
+// installConfig is the part of install-config.yaml used by the operator.
+type installConfig struct {
+	Networking struct {
+		MachineNetwork []struct {
+			CIDR string `json:"cidr"`
+		} `json:"machineNetwork"`
+	} `json:"networking"`
+}
+
+// getMachineNetworks returns CIDRs of the cluster machine network from
+// install-config. Missing install-config is no machine network.
+func (c *ManilaController) getMachineNetworks() ([]string, error) {
+	cm, err := c.installConfigLister.ConfigMaps(util.InstallConfigNamespace).Get(util.InstallConfigName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			klog.V(4).Infof("ConfigMap %s/%s not found, machine network is not known", util.InstallConfigNamespace, util.InstallConfigName)
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseMachineNetworks(cm.Data[installConfigKey])
+}
+
+func parseMachineNetworks(data string) ([]string, error) {
+	cfg := &installConfig{}
+	if err := yaml.Unmarshal([]byte(data), cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse key %s of ConfigMap %s/%s: %w", installConfigKey, util.InstallConfigNamespace, util.InstallConfigName, err)
+	}
+	var cidrs []string
+	for _, network := range cfg.Networking.MachineNetwork {
+		if _, _, err := net.ParseCIDR(network.CIDR); err != nil {
+			klog.Warningf("Ignoring machine network %q: %v", network.CIDR, err)
+			continue
+		}
+		cidrs = append(cidrs, network.CIDR)
+	}
+	return cidrs, nil
+}
+
+// getNFSShareClients returns clients allowed to access NFS shares: the
+// machine networks, unless overridden in the operator config, and the
+// additional clients from the config.
+func getNFSShareClients(machineNetworks []string, cfg *operatorConfig) []string {
+	clients := machineNetworks
+	if len(cfg.NFSShareClients) > 0 {
+		clients = cfg.NFSShareClients
+	}
+	var result []string
+	for _, client := range append(slices.Clone(clients), cfg.AdditionalNFSShareClients...) {
+		if !slices.Contains(result, client) {
+			result = append(result, client)
+		}
+	}
+	return result
+}
+
+// validateShareClient checks that the client is an IP address or a CIDR.
+func validateShareClient(client string) error {
+	if net.ParseIP(client) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(client); err != nil {
+		return fmt.Errorf("%q is not an IP address or CIDR", client)
+	}
+	return nil
+}
+
+// isWorldAccessible returns true when shares of the NFS StorageClass can be
+// mounted from any address.
+func isWorldAccessible(sc *storagev1.StorageClass) bool {
+	clients := sc.Parameters[nfsShareClientParameter]
+	if strings.TrimSpace(clients) == "" {
+		return true
+	}
+	for _, client := range strings.Split(clients, ",") {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(client))
+		if err != nil {
+			continue
+		}
+		if ones, _ := ipNet.Mask.Size(); ones == 0 {
+			return true
+		}
+	}
+	return false
+}
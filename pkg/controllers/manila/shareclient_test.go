@@ -0,0 +1,119 @@
+package manila
+
+import (
+	"reflect"
+	"testing"
+
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+func TestParseMachineNetworks(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		data        string
+		expectError bool
+		expected    []string
+	}{
+		{
+			name: "dual stack",
+			data: `
+apiVersion: v1
+networking:
+  networkType: OVNKubernetes
+  machineNetwork:
+  - cidr: 10.0.0.0/16
+  - cidr: fd2e:6f44:5dd8:c956::/64
+platform:
+  openstack:
+    cloud: openstack`,
+			expected: []string{"10.0.0.0/16", "fd2e:6f44:5dd8:c956::/64"},
+		},
+		{
+			name: "invalid CIDR",
+			data: `
+networking:
+  machineNetwork:
+  - cidr: 10.0.0.0
+  - cidr: 10.1.0.0/16`,
+			expected: []string{"10.1.0.0/16"},
+		},
+		{
+			name: "no machine network",
+			data: `networking: {}`,
+		},
+		{
+			name:        "invalid yaml",
+			data:        `networking: [`,
+			expectError: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cidrs, err := parseMachineNetworks(tc.data)
+			if err != nil {
+				if !tc.expectError {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tc.expectError {
+				t.Fatalf("expected error, got none")
+			}
+			if !reflect.DeepEqual(cidrs, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, cidrs)
+			}
+		})
+	}
+}
+
+func TestGetNFSShareClients(t *testing.T) {
+	machineNetworks := []string{"10.0.0.0/16"}
+	for _, tc := range []struct {
+		name     string
+		cfg      *operatorConfig
+		expected []string
+	}{
+		{
+			name:     "machine network",
+			cfg:      &operatorConfig{},
+			expected: []string{"10.0.0.0/16"},
+		},
+		{
+			name:     "additional clients",
+			cfg:      &operatorConfig{AdditionalNFSShareClients: []string{"192.168.0.10", "10.0.0.0/16"}},
+			expected: []string{"10.0.0.0/16", "192.168.0.10"},
+		},
+		{
+			name: "overridden clients",
+			cfg: &operatorConfig{
+				NFSShareClients:           []string{"10.0.1.0/24"},
+				AdditionalNFSShareClients: []string{"192.168.0.10"},
+			},
+			expected: []string{"10.0.1.0/24", "192.168.0.10"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clients := getNFSShareClients(machineNetworks, tc.cfg)
+			if !reflect.DeepEqual(clients, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, clients)
+			}
+		})
+	}
+}
+
+func TestIsWorldAccessible(t *testing.T) {
+	for clients, expected := range map[string]bool{
+		"":                        true,
+		"0.0.0.0/0":               true,
+		"10.0.0.0/16, ::/0":       true,
+		"10.0.0.0/16":             false,
+		"10.0.0.0/16,192.168.0.1": false,
+	} {
+		sc := &storagev1.StorageClass{Parameters: map[string]string{}}
+		if clients != "" {
+			sc.Parameters[nfsShareClientParameter] = clients
+		}
+		if worldAccessible := isWorldAccessible(sc); worldAccessible != expected {
+			t.Errorf("%q: expected %t, got %t", clients, expected, worldAccessible)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package manila
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// StorageClass parameter with JSON map of metadata the driver adds to
+	// each share.
+	appendShareMetadataParameter = "appendShareMetadata"
+
+	// Share metadata with infrastructureName of the cluster. The installer
+	// tags the cluster's OpenStack servers with the same key.
+	shareMetadataInfrastructureName = "openshiftClusterID"
+	// Share metadata with ClusterVersion clusterID.
+	shareMetadataClusterID = "openshiftClusterUUID"
+
+	clusterVersionName = "version"
+)
+
+// getShareMetadata returns value of appendShareMetadata StorageClass parameter
+// that ties shares to the cluster. Empty until both infrastructureName and
+// clusterID are known, so shares never get partial metadata and StorageClasses
+// are not recreated once more when the other half shows up.
+func (c *ManilaController) getShareMetadata() (string, error) {
+	infra, err := c.infraLister.Get(infrastructureName)
+	if err != nil && !errors.IsNotFound(err) {
+		return "", fmt.Errorf("failed to get Infrastructure %s: %w", infrastructureName, err)
+	}
+	if err != nil || infra.Status.InfrastructureName == "" {
+		return "", nil
+	}
+	cv, err := c.clusterVersionLister.Get(clusterVersionName)
+	if err != nil && !errors.IsNotFound(err) {
+		return "", fmt.Errorf("failed to get ClusterVersion %s: %w", clusterVersionName, err)
+	}
+	if err != nil || cv.Spec.ClusterID == "" {
+		return "", nil
+	}
+	metadata := map[string]string{
+		shareMetadataInfrastructureName: infra.Status.InfrastructureName,
+		shareMetadataClusterID:          string(cv.Spec.ClusterID),
+	}
+	// Map keys are sorted, the result is stable.
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,67 @@
+package manila
+
+import (
+	"testing"
+
+	configv1 "github.com/openshift/api/config/v1"
+	configlisters "github.com/openshift/client-go/config/listers/config/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestGetShareMetadata(t *testing.T) {
+	infra := &configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{Name: infrastructureName},
+		Status:     configv1.InfrastructureStatus{InfrastructureName: "mycluster-x7b2k"},
+	}
+	cv := &configv1.ClusterVersion{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterVersionName},
+		Spec:       configv1.ClusterVersionSpec{ClusterID: "0d6b1a6c-6d8f-4a43-9c1e-2f2a6f3e1c55"},
+	}
+	for _, tc := range []struct {
+		name     string
+		infra    *configv1.Infrastructure
+		cv       *configv1.ClusterVersion
+		expected string
+	}{
+		{
+			name:     "infrastructure and cluster version",
+			infra:    infra,
+			cv:       cv,
+			expected: `{"openshiftClusterID":"mycluster-x7b2k","openshiftClusterUUID":"0d6b1a6c-6d8f-4a43-9c1e-2f2a6f3e1c55"}`,
+		},
+		{
+			name:  "infrastructure only",
+			infra: infra,
+		},
+		{
+			name: "cluster version only",
+			cv:   cv,
+		},
+		{
+			name: "nothing known",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			infraIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			if tc.infra != nil {
+				infraIndexer.Add(tc.infra)
+			}
+			cvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			if tc.cv != nil {
+				cvIndexer.Add(tc.cv)
+			}
+			c := newTestController()
+			c.infraLister = configlisters.NewInfrastructureLister(infraIndexer)
+			c.clusterVersionLister = configlisters.NewClusterVersionLister(cvIndexer)
+
+			metadata, err := c.getShareMetadata()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if metadata != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, metadata)
+			}
+		})
+	}
+}
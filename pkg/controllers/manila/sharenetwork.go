@@ -0,0 +1,178 @@
+package manila
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharenetworks"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// shareNetworkDiscovery value that enables automatic share network
+	// discovery.
+	shareNetworkDiscoveryAuto = "Auto"
+	// shareNetworkDiscovery value that disables it, the same as empty value.
+	shareNetworkDiscoveryDisabled = "Disabled"
+
+	// Condition with the state of the share network used by DHSS=true
+	// share types.
+	shareNetworkReadyCondition = operatorConditionPrefix + "ShareNetworkReady"
+
+	// StorageClass parameter of the CSI driver with share network ID.
+	shareNetworkIDParameter = "shareNetworkID"
+
+	// Name of the cluster Infrastructure object.
+	infrastructureName = "cluster"
+
+	// Maximum number of nodes whose IP addresses are looked up in Neutron.
+	maxShareNetworkDiscoveryNodes = 3
+)
+
+// shareNetworkClient is the part of openStackClient used by share network
+// discovery.
+type shareNetworkClient interface {
+	FindPortByIP(ip string) (*ports.Port, error)
+	GetShareNetworks(neutronNetID, neutronSubnetID string) ([]sharenetworks.ShareNetwork, error)
+	CreateShareNetwork(opts sharenetworks.CreateOpts) (*sharenetworks.ShareNetwork, error)
+}
+
+// syncShareNetwork returns ID of Manila share network on the Neutron subnet
+// of the cluster nodes, see getOrCreateShareNetwork. The share network is
+// discovered once per share type poll, only by syncs of the Managed operator,
+// so polls never change anything in the cloud.
+//
+// Empty ID is returned when the discovery is disabled. When the discovery
+// fails, e.g. because Neutron is not available, the last discovered ID is
+// returned, so StorageClasses of DHSS=true share types are not garbage
+// collected. The result is reported in shareNetworkReadyCondition.
+func (c *ManilaController) syncShareNetwork(ctx context.Context, cfg *operatorConfig, snapshot *shareTypeSnapshot) (string, error) {
+	if cfg.ShareNetworkDiscovery != shareNetworkDiscoveryAuto {
+		c.shareNetworkID = ""
+		c.shareNetworkPoll = time.Time{}
+		_, _, err := v1helpers.UpdateStatus(ctx, c.operatorClient, removeConditionFn(shareNetworkReadyCondition))
+		return "", err
+	}
+	if c.shareNetworkPoll.Equal(snapshot.fetched) {
+		return c.shareNetworkID, nil
+	}
+
+	var shareNetwork *sharenetworks.ShareNetwork
+	var reason string
+	openstackClient, err := c.getOpenStackClient()
+	if err == nil {
+		shareNetwork, reason, err = c.getOrCreateShareNetwork(openstackClient)
+	}
+	c.shareNetworkPoll = snapshot.fetched
+	return c.reportShareNetwork(ctx, shareNetwork, reason, err)
+}
+
+// reportShareNetwork reports result of share network discovery in
+// shareNetworkReadyCondition and returns ID of the share network to use.
+func (c *ManilaController) reportShareNetwork(ctx context.Context, shareNetwork *sharenetworks.ShareNetwork, reason string, err error) (string, error) {
+	if err != nil {
+		klog.Warningf("Share network discovery failed: %v", err)
+		msg := err.Error()
+		if c.shareNetworkID != "" {
+			msg = fmt.Sprintf("%s, using the last discovered share network %s", msg, c.shareNetworkID)
+		}
+		return c.shareNetworkID, c.setCondition(ctx, shareNetworkReadyCondition, operatorv1.ConditionFalse, "DiscoveryFailed", msg)
+	}
+	c.shareNetworkID = shareNetwork.ID
+	msg := fmt.Sprintf("Using share network %s (%s) on Neutron network %s, subnet %s", shareNetwork.ID, shareNetwork.Name, shareNetwork.NeutronNetID, shareNetwork.NeutronSubnetID)
+	return shareNetwork.ID, c.setCondition(ctx, shareNetworkReadyCondition, operatorv1.ConditionTrue, reason, msg)
+}
+
+// getOrCreateShareNetwork finds the Neutron network and subnet of the cluster
+// nodes and returns Manila share network on that subnet and reason ("Found"
+// or "Created") for the condition. The share network is created when it does
+// not exist. Share networks created by the operator are named
+// "<infrastructure name>-share-network" and they are never deleted by the
+// operator, shares provisioned in them may outlive the cluster.
+func (c *ManilaController) getOrCreateShareNetwork(openstackClient shareNetworkClient) (*sharenetworks.ShareNetwork, string, error) {
+	infra, err := c.infraLister.Get(infrastructureName)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get Infrastructure %s: %w", infrastructureName, err)
+	}
+	if infra.Status.InfrastructureName == "" {
+		return nil, "", fmt.Errorf("Infrastructure %s has no infrastructureName", infrastructureName)
+	}
+	name := infra.Status.InfrastructureName + "-share-network"
+
+	netID, subnetID, err := c.getNodeNetwork(openstackClient)
+	if err != nil {
+		return nil, "", err
+	}
+
+	shareNetworks, err := openstackClient.GetShareNetworks(netID, subnetID)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(shareNetworks) > 0 {
+		// Prefer the share network created by the operator, then any
+		// other share network on the subnet.
+		sort.SliceStable(shareNetworks, func(i, j int) bool {
+			return shareNetworks[i].Name == name && shareNetworks[j].Name != name
+		})
+		klog.V(4).Infof("Found share network %s (%s) on subnet %s", shareNetworks[0].ID, shareNetworks[0].Name, subnetID)
+		return &shareNetworks[0], "Found", nil
+	}
+
+	klog.V(2).Infof("Creating share network %s on Neutron network %s, subnet %s", name, netID, subnetID)
+	shareNetwork, err := openstackClient.CreateShareNetwork(sharenetworks.CreateOpts{
+		NeutronNetID:    netID,
+		NeutronSubnetID: subnetID,
+		Name:            name,
+		Description:     fmt.Sprintf("Created by OpenShift Manila CSI driver operator for cluster %s", infra.Status.InfrastructureName),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	c.eventRecorder.Eventf("ShareNetworkCreated", "Created share network %s (%s)", shareNetwork.ID, shareNetwork.Name)
+	return shareNetwork, "Created", nil
+}
+
+// getNodeNetwork returns Neutron network and subnet IDs of the cluster nodes,
+// as found by Neutron ports of node InternalIP addresses.
+func (c *ManilaController) getNodeNetwork(openstackClient shareNetworkClient) (string, string, error) {
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return "", "", err
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+
+	checked := 0
+	for _, node := range nodes {
+		for _, addr := range node.Status.Addresses {
+			if addr.Type != corev1.NodeInternalIP {
+				continue
+			}
+			if checked >= maxShareNetworkDiscoveryNodes {
+				return "", "", fmt.Errorf("no Neutron port found for InternalIP of %d nodes", checked)
+			}
+			checked++
+
+			port, err := openstackClient.FindPortByIP(addr.Address)
+			if err != nil {
+				return "", "", err
+			}
+			if port == nil {
+				klog.V(4).Infof("No Neutron port found for node %s IP %s", node.Name, addr.Address)
+				continue
+			}
+			for _, fixedIP := range port.FixedIPs {
+				if fixedIP.IPAddress == addr.Address {
+					return port.NetworkID, fixedIP.SubnetID, nil
+				}
+			}
+		}
+	}
+	return "", "", fmt.Errorf("no Neutron port found for InternalIP of any node")
+}
@@ -0,0 +1,261 @@
+package manila
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharenetworks"
+	configv1 "github.com/openshift/api/config/v1"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	configlisters "github.com/openshift/client-go/config/listers/config/v1"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+type fakeShareNetworkClient struct {
+	// Neutron ports by IP address.
+	ports         map[string]*ports.Port
+	shareNetworks []sharenetworks.ShareNetwork
+	portErr       error
+	created       []sharenetworks.CreateOpts
+}
+
+var _ shareNetworkClient = &fakeShareNetworkClient{}
+
+func (f *fakeShareNetworkClient) FindPortByIP(ip string) (*ports.Port, error) {
+	if f.portErr != nil {
+		return nil, f.portErr
+	}
+	return f.ports[ip], nil
+}
+
+func (f *fakeShareNetworkClient) GetShareNetworks(neutronNetID, neutronSubnetID string) ([]sharenetworks.ShareNetwork, error) {
+	var found []sharenetworks.ShareNetwork
+	for _, sn := range f.shareNetworks {
+		if sn.NeutronNetID == neutronNetID && sn.NeutronSubnetID == neutronSubnetID {
+			found = append(found, sn)
+		}
+	}
+	return found, nil
+}
+
+func (f *fakeShareNetworkClient) CreateShareNetwork(opts sharenetworks.CreateOpts) (*sharenetworks.ShareNetwork, error) {
+	f.created = append(f.created, opts)
+	return &sharenetworks.ShareNetwork{
+		ID:              "created-id",
+		Name:            opts.Name,
+		NeutronNetID:    opts.NeutronNetID,
+		NeutronSubnetID: opts.NeutronSubnetID,
+	}, nil
+}
+
+func newTestNode(name, ip string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeHostName, Address: name},
+				{Type: corev1.NodeInternalIP, Address: ip},
+			},
+		},
+	}
+}
+
+func newTestPort(netID, subnetID, ip string) *ports.Port {
+	return &ports.Port{
+		NetworkID: netID,
+		FixedIPs:  []ports.IP{{SubnetID: subnetID, IPAddress: ip}},
+	}
+}
+
+func TestGetOrCreateShareNetwork(t *testing.T) {
+	nodes := []*corev1.Node{
+		newTestNode("worker-0", "192.168.0.5"),
+		newTestNode("master-0", "10.0.0.5"),
+	}
+	nodePorts := map[string]*ports.Port{
+		"10.0.0.5":    newTestPort("net-a", "subnet-a", "10.0.0.5"),
+		"192.168.0.5": newTestPort("net-b", "subnet-b", "192.168.0.5"),
+	}
+	for _, tc := range []struct {
+		name                  string
+		nodes                 []*corev1.Node
+		client                *fakeShareNetworkClient
+		expectedID            string
+		expectedReason        string
+		expectedCreatedName   string
+		expectedCreatedSubnet string
+		expectError           bool
+	}{
+		{
+			name:  "existing share network",
+			nodes: nodes,
+			client: &fakeShareNetworkClient{
+				ports: nodePorts,
+				shareNetworks: []sharenetworks.ShareNetwork{
+					{ID: "other-id", Name: "other", NeutronNetID: "net-a", NeutronSubnetID: "subnet-a"},
+					{ID: "own-id", Name: "mycluster-x7b2k-share-network", NeutronNetID: "net-a", NeutronSubnetID: "subnet-a"},
+				},
+			},
+			expectedID:     "own-id",
+			expectedReason: "Found",
+		},
+		{
+			name:  "share network of another name",
+			nodes: nodes,
+			client: &fakeShareNetworkClient{
+				ports: nodePorts,
+				shareNetworks: []sharenetworks.ShareNetwork{
+					{ID: "other-id", Name: "other", NeutronNetID: "net-a", NeutronSubnetID: "subnet-a"},
+					{ID: "subnet-b-id", Name: "mycluster-x7b2k-share-network", NeutronNetID: "net-b", NeutronSubnetID: "subnet-b"},
+				},
+			},
+			expectedID:     "other-id",
+			expectedReason: "Found",
+		},
+		{
+			name:                  "create share network",
+			nodes:                 nodes,
+			client:                &fakeShareNetworkClient{ports: nodePorts},
+			expectedID:            "created-id",
+			expectedReason:        "Created",
+			expectedCreatedName:   "mycluster-x7b2k-share-network",
+			expectedCreatedSubnet: "subnet-a",
+		},
+		{
+			name: "first node without port",
+			nodes: []*corev1.Node{
+				newTestNode("master-0", "10.0.0.99"),
+				newTestNode("worker-0", "192.168.0.5"),
+			},
+			client:                &fakeShareNetworkClient{ports: nodePorts},
+			expectedID:            "created-id",
+			expectedReason:        "Created",
+			expectedCreatedName:   "mycluster-x7b2k-share-network",
+			expectedCreatedSubnet: "subnet-b",
+		},
+		{
+			name: "no matching port",
+			nodes: []*corev1.Node{
+				newTestNode("master-0", "10.0.0.99"),
+				newTestNode("master-1", "10.0.0.98"),
+			},
+			client:      &fakeShareNetworkClient{ports: nodePorts},
+			expectError: true,
+		},
+		{
+			name:        "Neutron error",
+			nodes:       nodes,
+			client:      &fakeShareNetworkClient{portErr: errors.New("connection refused")},
+			expectError: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			infraIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			infraIndexer.Add(&configv1.Infrastructure{
+				ObjectMeta: metav1.ObjectMeta{Name: infrastructureName},
+				Status:     configv1.InfrastructureStatus{InfrastructureName: "mycluster-x7b2k"},
+			})
+			var objs []runtime.Object
+			for _, node := range tc.nodes {
+				objs = append(objs, node)
+			}
+			c := newTestController(objs...)
+			c.infraLister = configlisters.NewInfrastructureLister(infraIndexer)
+
+			shareNetwork, reason, err := c.getOrCreateShareNetwork(tc.client)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got share network %+v", shareNetwork)
+				}
+				if len(tc.client.created) > 0 {
+					t.Errorf("expected no share network created, got %+v", tc.client.created)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if shareNetwork.ID != tc.expectedID || reason != tc.expectedReason {
+				t.Errorf("expected share network %s (%s), got %s (%s)", tc.expectedID, tc.expectedReason, shareNetwork.ID, reason)
+			}
+			if tc.expectedCreatedName == "" {
+				if len(tc.client.created) > 0 {
+					t.Errorf("expected no share network created, got %+v", tc.client.created)
+				}
+				return
+			}
+			if len(tc.client.created) != 1 || tc.client.created[0].Name != tc.expectedCreatedName || tc.client.created[0].NeutronSubnetID != tc.expectedCreatedSubnet {
+				t.Errorf("expected share network %s created on %s, got %+v", tc.expectedCreatedName, tc.expectedCreatedSubnet, tc.client.created)
+			}
+		})
+	}
+}
+
+func TestSyncShareNetwork(t *testing.T) {
+	c := newTestController()
+	c.operatorClient = v1helpers.NewFakeOperatorClient(&operatorv1.OperatorSpec{}, &operatorv1.OperatorStatus{}, nil)
+	snapshot := &shareTypeSnapshot{fetched: time.Now()}
+
+	// The share network is discovered once per poll.
+	c.shareNetworkID = "sn-1"
+	c.shareNetworkPoll = snapshot.fetched
+	id, err := c.syncShareNetwork(context.TODO(), &operatorConfig{ShareNetworkDiscovery: shareNetworkDiscoveryAuto}, snapshot)
+	if err != nil || id != "sn-1" {
+		t.Errorf("expected share network sn-1, got %q: %v", id, err)
+	}
+
+	// Disabled discovery forgets the share network.
+	id, err = c.syncShareNetwork(context.TODO(), &operatorConfig{}, snapshot)
+	if err != nil || id != "" {
+		t.Errorf("expected no share network, got %q: %v", id, err)
+	}
+	if !c.shareNetworkPoll.IsZero() {
+		t.Errorf("expected the share network to be discovered again after enabling")
+	}
+}
+
+func TestReportShareNetwork(t *testing.T) {
+	c := newTestController()
+	c.operatorClient = v1helpers.NewFakeOperatorClient(&operatorv1.OperatorSpec{}, &operatorv1.OperatorStatus{}, nil)
+
+	for _, step := range []struct {
+		name           string
+		shareNetwork   *sharenetworks.ShareNetwork
+		err            error
+		expectedID     string
+		expectedStatus operatorv1.ConditionStatus
+	}{
+		{
+			name:           "discovered",
+			shareNetwork:   &sharenetworks.ShareNetwork{ID: "sn-1"},
+			expectedID:     "sn-1",
+			expectedStatus: operatorv1.ConditionTrue,
+		},
+		{
+			name:           "discovery failed",
+			err:            errors.New("connection refused"),
+			expectedID:     "sn-1",
+			expectedStatus: operatorv1.ConditionFalse,
+		},
+	} {
+		id, err := c.reportShareNetwork(context.TODO(), step.shareNetwork, "Found", step.err)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+		if id != step.expectedID {
+			t.Errorf("%s: expected share network %q, got %q", step.name, step.expectedID, id)
+		}
+		_, status, _, _ := c.operatorClient.GetOperatorState()
+		cnd := v1helpers.FindOperatorCondition(status.Conditions, shareNetworkReadyCondition)
+		if cnd == nil || cnd.Status != step.expectedStatus {
+			t.Errorf("%s: expected %s condition %s, got %+v", step.name, shareNetworkReadyCondition, step.expectedStatus, cnd)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package manila
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
+)
+
+// Manila share type extra specs used by the operator.
+// See https://docs.openstack.org/manila/latest/admin/capabilities_and_extra_specs.html
+const (
+	extraSpecDHSS                      = "driver_handles_share_servers"
+	extraSpecSnapshotSupport           = "snapshot_support"
+	extraSpecCreateFromSnapshotSupport = "create_share_from_snapshot_support"
+	extraSpecAvailabilityZones         = "availability_zones"
+	extraSpecStorageProtocol           = "storage_protocol"
+)
+
+// Annotations of generated StorageClasses with capabilities of their share type.
+const (
+	shareTypeNameAnnotation             = "manila.csi.openstack.org/share-type"
+	dhssAnnotation                      = "manila.csi.openstack.org/driver-handles-share-servers"
+	snapshotSupportAnnotation           = "manila.csi.openstack.org/snapshot-support"
+	createFromSnapshotSupportAnnotation = "manila.csi.openstack.org/create-share-from-snapshot-support"
+	availabilityZonesAnnotation         = "manila.csi.openstack.org/availability-zones"
+	extendSupportAnnotation             = "manila.csi.openstack.org/extend-support"
+)
+
+// shareTypeCapabilities are capabilities of a share type, as parsed from its
+// extra specs.
+type shareTypeCapabilities struct {
+	// DHSS=true share types need a share network to provision shares.
+	DriverHandlesShareServers bool
+	SnapshotSupport           bool
+	CreateFromSnapshotSupport bool
+	// Manila has no extra spec for extending shares, all share types can be
+	// extended when Manila API supports it.
+	ExtendSupport bool
+	// AvailabilityZones the share type is restricted to. Empty means all
+	// availability zones.
+	AvailabilityZones []string
+	// Protocols supported by both the share type and the operator, NFS
+	// first. Empty when the share type supports only other protocols.
+	Protocols []string
+}
+
+func getShareTypeCapabilities(shareType sharetypes.ShareType) shareTypeCapabilities {
+	caps := shareTypeCapabilities{
+		DriverHandlesShareServers: getBoolExtraSpec(shareType, extraSpecDHSS),
+		SnapshotSupport:           getBoolExtraSpec(shareType, extraSpecSnapshotSupport),
+		CreateFromSnapshotSupport: getBoolExtraSpec(shareType, extraSpecCreateFromSnapshotSupport),
+		ExtendSupport:             true,
+	}
+	if azs, ok := getExtraSpec(shareType, extraSpecAvailabilityZones); ok {
+		for _, az := range strings.Split(azs, ",") {
+			if az = strings.TrimSpace(az); az != "" {
+				caps.AvailabilityZones = append(caps.AvailabilityZones, az)
+			}
+		}
+	}
+	caps.Protocols = getShareTypeProtocols(shareType)
+	return caps
+}
+
+// annotations returns StorageClass annotations that describe the capabilities.
+func (caps shareTypeCapabilities) annotations() map[string]string {
+	annotations := map[string]string{
+		dhssAnnotation:                      strconv.FormatBool(caps.DriverHandlesShareServers),
+		snapshotSupportAnnotation:           strconv.FormatBool(caps.SnapshotSupport),
+		createFromSnapshotSupportAnnotation: strconv.FormatBool(caps.CreateFromSnapshotSupport),
+		extendSupportAnnotation:             strconv.FormatBool(caps.ExtendSupport),
+	}
+	if len(caps.AvailabilityZones) > 0 {
+		annotations[availabilityZonesAnnotation] = strings.Join(caps.AvailabilityZones, ",")
+	}
+	return annotations
+}
+
+// getExtraSpec returns value of an extra spec of the share type. Required
+// extra specs (such as driver_handles_share_servers) are reported by Manila
+// in both required_extra_specs and extra_specs, depending on the caller's
+// privileges, so both are checked.
+func getExtraSpec(shareType sharetypes.ShareType, key string) (string, bool) {
+	for _, specs := range []map[string]any{shareType.ExtraSpecs, shareType.RequiredExtraSpecs} {
+		if value, ok := specs[key]; ok && value != nil {
+			return fmt.Sprint(value), true
+		}
+	}
+	return "", false
+}
+
+// getBoolExtraSpec parses boolean extra spec. Manila accepts both "True" and
+// "<is> True" forms, case-insensitive. Missing spec is false.
+func getBoolExtraSpec(shareType sharetypes.ShareType, key string) bool {
+	value, ok := getExtraSpec(shareType, key)
+	if !ok {
+		return false
+	}
+	value = strings.TrimSpace(value)
+	if prefix := "<is>"; len(value) >= len(prefix) && strings.EqualFold(value[:len(prefix)], prefix) {
+		value = strings.TrimSpace(value[len(prefix):])
+	}
+	return strings.EqualFold(value, "true")
+}
@@ -0,0 +1,235 @@
+package manila
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/shares"
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Env. variable with interval of Manila share type polling, in
+	// time.ParseDuration format.
+	shareTypePollIntervalEnvName = "SHARE_TYPE_POLL_INTERVAL"
+	defaultShareTypePollInterval = 5 * time.Minute
+	// Initial delay before a failed poll is retried. It's doubled after each
+	// failure, up to the poll interval.
+	minShareTypePollBackoff = 10 * time.Second
+	// Share types are reported stale when they were not fetched for this
+	// many poll intervals.
+	shareTypeStalenessFactor = 3
+
+	// Condition reporting that the share types used by the controller are
+	// stale, because Manila could not be polled.
+	shareTypesStaleCondition = operatorConditionPrefix + "ShareTypesStale"
+	// Annotation of ClusterCSIDriver. Any change of its value forces an
+	// immediate share type poll.
+	refreshShareTypesAnnotation = "manila.csi.openstack.org/refresh-share-types"
+)
+
+// shareTypeSnapshot is a result of a successful poll of Manila.
+type shareTypeSnapshot struct {
+	shareTypes []sharetypes.ShareType
+	// ID of Manila default share type. Empty when Manila has no default share
+	// type or it could not be fetched.
+	defaultShareTypeID string
+	// Capabilities of Manila API, nil when they could not be detected.
+	apiCapabilities *apiCapabilities
+	// Manila quotas and their usage, nil when they could not be fetched.
+	quotas []quotaUsage
+	// All shares of the project, nil when they could not be fetched.
+	shares []shares.Share
+	// Time when the share types were fetched.
+	fetched time.Time
+}
+
+// shareTypeCache polls Manila share types in the background, so syncs of the
+// controller do not call Manila API and they survive short Manila outages.
+type shareTypeCache struct {
+	interval time.Duration
+	fetch    func() (*shareTypeSnapshot, error)
+	// Buffered channel that requests an immediate poll.
+	refreshCh chan struct{}
+
+	lock sync.Mutex
+	// The last successful poll.
+	snapshot *shareTypeSnapshot
+	// Error of the last poll, nil when it succeeded.
+	lastErr error
+}
+
+func newShareTypeCache(interval time.Duration, fetch func() (*shareTypeSnapshot, error)) *shareTypeCache {
+	return &shareTypeCache{
+		interval:  interval,
+		fetch:     fetch,
+		refreshCh: make(chan struct{}, 1),
+	}
+}
+
+func getShareTypePollInterval() time.Duration {
+	intervalFromEnv := os.Getenv(shareTypePollIntervalEnvName)
+	if intervalFromEnv == "" {
+		return defaultShareTypePollInterval
+	}
+	interval, err := time.ParseDuration(intervalFromEnv)
+	if err != nil || interval <= 0 {
+		klog.V(4).Infof("Invalid %s %q. Ignoring.", shareTypePollIntervalEnvName, intervalFromEnv)
+		return defaultShareTypePollInterval
+	}
+	return interval
+}
+
+// get returns the last successful snapshot and error of the last poll. Both
+// are nil before the first poll finishes.
+func (s *shareTypeCache) get() (*shareTypeSnapshot, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.snapshot, s.lastErr
+}
+
+// refresh requests an immediate poll.
+func (s *shareTypeCache) refresh() {
+	select {
+	case s.refreshCh <- struct{}{}:
+	default:
+		// A refresh is already pending.
+	}
+}
+
+// run polls Manila until the context is cancelled. onUpdate is called after
+// each poll.
+func (s *shareTypeCache) run(ctx context.Context, onUpdate func()) {
+	backoff := minShareTypePollBackoff
+	for {
+		wait := s.interval
+		if err := s.poll(); err != nil {
+			klog.Warningf("Failed to poll Manila share types, retrying in %s: %v", backoff, err)
+			wait = backoff
+			backoff = min(2*backoff, s.interval)
+		} else {
+			backoff = minShareTypePollBackoff
+		}
+		onUpdate()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.refreshCh:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *shareTypeCache) poll() error {
+	snapshot, err := s.fetch()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastErr = err
+	if err == nil {
+		s.snapshot = snapshot
+	}
+	return err
+}
+
+// isStale returns true when the snapshot was not refreshed for too long.
+func (s *shareTypeCache) isStale(snapshot *shareTypeSnapshot, now time.Time) bool {
+	return now.Sub(snapshot.fetched) > shareTypeStalenessFactor*s.interval
+}
+
+// fetchShareTypes fetches share types, the default share type, API
+// capabilities and quotas from Manila. Failure to get anything but the share
+// types is not fatal.
+func (c *ManilaController) fetchShareTypes() (*shareTypeSnapshot, error) {
+	openstackClient, err := c.getOpenStackClient()
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to OpenStack: %w", err)
+	}
+	shareTypes, err := openstackClient.GetShareTypes()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &shareTypeSnapshot{
+		shareTypes: shareTypes,
+		fetched:    now(),
+	}
+
+	defaultShareType, err := openstackClient.GetDefaultShareType()
+	switch {
+	case err != nil:
+		klog.Warningf("Unable to retrieve Manila default share type: %v", err)
+	case defaultShareType == nil:
+		klog.V(4).Infof("Manila has no default share type")
+	default:
+		snapshot.defaultShareTypeID = defaultShareType.ID
+	}
+
+	snapshot.apiCapabilities, err = openstackClient.GetAPICapabilities()
+	if err != nil {
+		klog.Warningf("Unable to detect Manila API capabilities: %v", err)
+	}
+
+	snapshot.quotas, err = fetchQuotas(openstackClient, shareTypes, snapshot.apiCapabilities)
+	if err != nil {
+		klog.Warningf("Unable to retrieve Manila quotas: %v", err)
+	}
+
+	snapshot.shares, err = openstackClient.GetShares()
+	if err != nil {
+		klog.Warningf("Unable to list Manila shares: %v", err)
+	}
+	return snapshot, nil
+}
+
+// checkRefreshAnnotation requests an immediate share type poll when the
+// refresh annotation of ClusterCSIDriver changes. The value found on the
+// first sync is not a change, share types are polled on startup anyway.
+func (c *ManilaController) checkRefreshAnnotation() {
+	ccd, err := c.ccdLister.Get(string(operatorv1.ManilaCSIDriver))
+	if err != nil {
+		klog.V(4).Infof("Unable to get ClusterCSIDriver: %v", err)
+		return
+	}
+	value, ok := ccd.Annotations[refreshShareTypesAnnotation]
+	if c.refreshAnnotation != nil && ok && value != *c.refreshAnnotation {
+		klog.V(2).Infof("Refreshing Manila share types, requested by %s annotation", refreshShareTypesAnnotation)
+		c.shareTypeCache.refresh()
+	}
+	c.refreshAnnotation = &value
+}
+
+// reportPollError reports error of the last share type poll in
+// openStackDegradedCondition, e.g. rejected credentials. The condition is
+// removed after a successful poll. Syncs continue with the last polled share
+// types.
+func (c *ManilaController) reportPollError(ctx context.Context, snapshot *shareTypeSnapshot, pollErr error) error {
+	if pollErr == nil {
+		return c.updateCondition(ctx, openStackDegradedCondition, "", "")
+	}
+	msg := fmt.Sprintf("Unable to retrieve Manila share types, using the ones fetched at %s: %v", snapshot.fetched.UTC().Format(time.RFC3339), pollErr)
+	return c.updateCondition(ctx, openStackDegradedCondition, classifyOpenStackError(pollErr), msg)
+}
+
+// reportShareTypesStaleness reports stale share types in a condition.
+func (c *ManilaController) reportShareTypesStaleness(ctx context.Context, snapshot *shareTypeSnapshot, pollErr error) error {
+	var msg string
+	reason := classifyOpenStackError(pollErr)
+	if reason == "" {
+		reason = reasonOpenStackError
+	}
+	if c.shareTypeCache.isStale(snapshot, now()) {
+		msg = fmt.Sprintf("Manila share types were last fetched at %s", snapshot.fetched.UTC().Format(time.RFC3339))
+		if pollErr != nil {
+			msg += fmt.Sprintf(": %v", pollErr)
+		}
+	}
+	return c.updateCondition(ctx, shareTypesStaleCondition, reason, msg)
+}
@@ -0,0 +1,136 @@
+package manila
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+)
+
+func TestShareTypeCache(t *testing.T) {
+	results := make(chan error, 10)
+	fetch := func() (*shareTypeSnapshot, error) {
+		if err := <-results; err != nil {
+			return nil, err
+		}
+		return &shareTypeSnapshot{
+			shareTypes: []sharetypes.ShareType{{ID: "id1", Name: "default"}},
+			fetched:    time.Now(),
+		}, nil
+	}
+	// Long interval, polls after the first one happen only on refresh.
+	cache := newShareTypeCache(time.Hour, fetch)
+	updates := make(chan struct{}, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if snapshot, err := cache.get(); snapshot != nil || err != nil {
+		t.Fatalf("expected empty cache, got %+v, %v", snapshot, err)
+	}
+
+	results <- nil
+	go cache.run(ctx, func() { updates <- struct{}{} })
+	<-updates
+	snapshot, err := cache.get()
+	if err != nil || snapshot == nil || len(snapshot.shareTypes) != 1 {
+		t.Fatalf("unexpected result of the first poll: %+v, %v", snapshot, err)
+	}
+
+	// Failed poll keeps the last snapshot.
+	results <- errors.New("Manila is down")
+	cache.refresh()
+	<-updates
+	failedSnapshot, err := cache.get()
+	if err == nil {
+		t.Errorf("expected poll error")
+	}
+	if failedSnapshot != snapshot {
+		t.Errorf("expected the last successful snapshot, got %+v", failedSnapshot)
+	}
+
+	if cache.isStale(snapshot, time.Now()) {
+		t.Errorf("fresh snapshot reported as stale")
+	}
+	if !cache.isStale(snapshot, time.Now().Add(4*time.Hour)) {
+		t.Errorf("old snapshot not reported as stale")
+	}
+}
+
+func TestShareTypePollStartStop(t *testing.T) {
+	polls := make(chan struct{}, 10)
+	c := newTestController()
+	c.shareTypeCache = newShareTypeCache(time.Hour, func() (*shareTypeSnapshot, error) {
+		polls <- struct{}{}
+		return &shareTypeSnapshot{fetched: time.Now()}, nil
+	})
+	syncCtx := factory.NewSyncContext("test", events.NewInMemoryRecorder("test"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c.startShareTypePollIfNeeded(ctx, syncCtx)
+	// Already running, no second poll loop.
+	c.startShareTypePollIfNeeded(ctx, syncCtx)
+	<-polls
+	c.stopShareTypePollIfRunning()
+	if c.stopShareTypePoll != nil {
+		t.Fatalf("expected the poll to be stopped")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(polls) != 0 {
+		t.Errorf("unexpected poll after stop")
+	}
+	if syncCtx.Queue().Len() != 1 {
+		t.Errorf("expected a sync queued by the poll, got %d", syncCtx.Queue().Len())
+	}
+
+	// Started again, e.g. when the operator is Managed again.
+	c.startShareTypePollIfNeeded(ctx, syncCtx)
+	<-polls
+	c.stopShareTypePollIfRunning()
+}
+
+func TestReportPollError(t *testing.T) {
+	c := newTestController()
+	c.operatorClient = v1helpers.NewFakeOperatorClient(&operatorv1.OperatorSpec{}, &operatorv1.OperatorStatus{}, nil)
+	snapshot := &shareTypeSnapshot{fetched: time.Now()}
+
+	for _, step := range []struct {
+		name           string
+		pollErr        error
+		expectedReason string
+	}{
+		{
+			name:           "rejected credentials",
+			pollErr:        gophercloud.ErrUnexpectedResponseCode{Actual: http.StatusUnauthorized},
+			expectedReason: reasonAuthFailed,
+		},
+		{
+			name:           "unreachable",
+			pollErr:        gophercloud.ErrUnexpectedResponseCode{Actual: http.StatusServiceUnavailable},
+			expectedReason: reasonUnreachable,
+		},
+		{
+			name: "successful poll",
+		},
+	} {
+		if err := c.reportPollError(context.TODO(), snapshot, step.pollErr); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+		_, status, _, _ := c.operatorClient.GetOperatorState()
+		cnd := v1helpers.FindOperatorCondition(status.Conditions, openStackDegradedCondition)
+		switch {
+		case step.expectedReason == "" && cnd != nil:
+			t.Errorf("%s: expected no %s condition, got %+v", step.name, openStackDegradedCondition, cnd)
+		case step.expectedReason != "" && (cnd == nil || cnd.Status != operatorv1.ConditionTrue || cnd.Reason != step.expectedReason):
+			t.Errorf("%s: expected %s condition with reason %s, got %+v", step.name, openStackDegradedCondition, step.expectedReason, cnd)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package manila
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
+)
+
+func TestGetShareTypeCapabilities(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		shareType sharetypes.ShareType
+		expected  shareTypeCapabilities
+	}{
+		{
+			name:      "no extra specs",
+			shareType: sharetypes.ShareType{Name: "default"},
+			expected:  shareTypeCapabilities{ExtendSupport: true, Protocols: []string{"NFS"}},
+		},
+		{
+			name: "all capabilities",
+			shareType: sharetypes.ShareType{
+				Name: "gold",
+				RequiredExtraSpecs: map[string]any{
+					"driver_handles_share_servers": "True",
+				},
+				ExtraSpecs: map[string]any{
+					"snapshot_support":                   "<is> True",
+					"create_share_from_snapshot_support": "true",
+					"availability_zones":                 "nova, az2,",
+					"storage_protocol":                   "<in> CEPHFS NFS",
+				},
+			},
+			expected: shareTypeCapabilities{
+				DriverHandlesShareServers: true,
+				SnapshotSupport:           true,
+				CreateFromSnapshotSupport: true,
+				ExtendSupport:             true,
+				AvailabilityZones:         []string{"nova", "az2"},
+				Protocols:                 []string{"NFS", "CEPHFS"},
+			},
+		},
+		{
+			name: "disabled capabilities",
+			shareType: sharetypes.ShareType{
+				Name: "silver",
+				ExtraSpecs: map[string]any{
+					"driver_handles_share_servers": "False",
+					"snapshot_support":             "<is> False",
+				},
+			},
+			expected: shareTypeCapabilities{ExtendSupport: true, Protocols: []string{"NFS"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			caps := getShareTypeCapabilities(tc.shareType)
+			if !reflect.DeepEqual(caps, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, caps)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package manila
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/csi-driver-manila-operator/pkg/util"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceapply"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	k8serrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Env. variable with the time a share type must be missing in Manila
+	// before its StorageClass is deleted, in time.ParseDuration format.
+	gcGracePeriodEnvName = "STORAGECLASS_GC_GRACE_PERIOD"
+	// Default grace period. A share type may disappear only temporarily,
+	// e.g. during Manila reconfiguration.
+	defaultGCGracePeriod = 24 * time.Hour
+)
+
+// now is replaceable in unit tests.
+var now = time.Now
+
+func getGCGracePeriod() time.Duration {
+	gracePeriodFromEnv := os.Getenv(gcGracePeriodEnvName)
+	if gracePeriodFromEnv == "" {
+		return defaultGCGracePeriod
+	}
+	gracePeriod, err := time.ParseDuration(gracePeriodFromEnv)
+	if err != nil || gracePeriod < 0 {
+		klog.V(4).Infof("Invalid %s %q. Ignoring.", gcGracePeriodEnvName, gracePeriodFromEnv)
+		return defaultGCGracePeriod
+	}
+	return gracePeriod
+}
+
+// removeStaleStorageClasses deletes StorageClasses generated by the operator
+// that are not expected anymore, typically because their share type is not
+// provided by Manila anymore.
+//   - With Managed StorageClassState, a StorageClass is deleted only after
+//     its share type has been missing for the grace period and no bound PV or
+//     pending PVC uses it. Time when the share type was first found missing is
+//     stored in an annotation of the StorageClass, so it survives operator
+//     restarts.
+//   - With Removed StorageClassState, all generated StorageClasses are deleted.
+//   - With Unmanaged StorageClassState, nothing is touched.
+func (c *ManilaController) removeStaleStorageClasses(ctx context.Context, expectedSCs []*storagev1.StorageClass, scState operatorv1.StorageClassStateName) error {
+	if scState == operatorv1.UnmanagedStorageClass {
+		return nil
+	}
+
+	expectedNames := sets.New[string]()
+	for _, sc := range expectedSCs {
+		expectedNames.Insert(sc.Name)
+	}
+
+	generatedSCs, err := c.listGeneratedStorageClasses()
+	if err != nil {
+		return err
+	}
+
+	gracePeriod := getGCGracePeriod()
+	var errs []error
+	for _, sc := range generatedSCs {
+		var err error
+		switch {
+		case scState == operatorv1.RemovedStorageClass:
+			_, _, err = resourceapply.DeleteStorageClass(ctx, c.kubeClient.StorageV1(), c.eventRecorder, sc)
+		case expectedNames.Has(sc.Name):
+			err = c.clearMissingSince(ctx, sc)
+		default:
+			err = c.collectStorageClass(ctx, sc, gracePeriod)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return k8serrors.NewAggregate(errs)
+}
+
+func (c *ManilaController) listGeneratedStorageClasses() ([]*storagev1.StorageClass, error) {
+	req, err := labels.NewRequirement(util.ShareTypeIDLabel, selection.Exists, nil)
+	if err != nil {
+		return nil, err
+	}
+	scs, err := c.storageClassLister.List(labels.NewSelector().Add(*req))
+	if err != nil {
+		return nil, err
+	}
+	var generated []*storagev1.StorageClass
+	for _, sc := range scs {
+		if isManilaDriver(sc.Provisioner) {
+			generated = append(generated, sc)
+		}
+	}
+	return generated, nil
+}
+
+// clearMissingSince removes the missing-since annotation from StorageClass
+// whose share type re-appeared in Manila.
+func (c *ManilaController) clearMissingSince(ctx context.Context, sc *storagev1.StorageClass) error {
+	if _, ok := sc.Annotations[util.ShareTypeMissingSinceAnnotation]; !ok {
+		return nil
+	}
+	klog.V(2).Infof("Share type %s of StorageClass %s is back in Manila", sc.Labels[util.ShareTypeIDLabel], sc.Name)
+	sc = sc.DeepCopy()
+	delete(sc.Annotations, util.ShareTypeMissingSinceAnnotation)
+	_, err := c.kubeClient.StorageV1().StorageClasses().Update(ctx, sc, metav1.UpdateOptions{})
+	return err
+}
+
+func (c *ManilaController) collectStorageClass(ctx context.Context, sc *storagev1.StorageClass, gracePeriod time.Duration) error {
+	shareTypeID := sc.Labels[util.ShareTypeIDLabel]
+
+	missingSinceStr, ok := sc.Annotations[util.ShareTypeMissingSinceAnnotation]
+	missingSince, err := time.Parse(time.RFC3339, missingSinceStr)
+	if !ok || err != nil {
+		klog.V(2).Infof("Share type %s of StorageClass %s is missing in Manila, it will be deleted after %s", shareTypeID, sc.Name, gracePeriod)
+		sc = sc.DeepCopy()
+		metav1.SetMetaDataAnnotation(&sc.ObjectMeta, util.ShareTypeMissingSinceAnnotation, now().UTC().Format(time.RFC3339))
+		_, err := c.kubeClient.StorageV1().StorageClasses().Update(ctx, sc, metav1.UpdateOptions{})
+		return err
+	}
+
+	if now().Sub(missingSince) < gracePeriod {
+		klog.V(4).Infof("Share type %s of StorageClass %s is missing since %s, keeping the StorageClass until grace period expires", shareTypeID, sc.Name, missingSinceStr)
+		return nil
+	}
+
+	inUse, err := c.isStorageClassInUse(sc.Name)
+	if err != nil {
+		return err
+	}
+	if inUse != "" {
+		klog.V(2).Infof("Not deleting StorageClass %s of missing share type %s: %s", sc.Name, shareTypeID, inUse)
+		return nil
+	}
+
+	klog.V(2).Infof("Deleting StorageClass %s, share type %s is missing in Manila since %s", sc.Name, shareTypeID, missingSinceStr)
+	_, _, err = resourceapply.DeleteStorageClass(ctx, c.kubeClient.StorageV1(), c.eventRecorder, sc)
+	return err
+}
+
+// isStorageClassInUse returns a reason why the StorageClass cannot be deleted
+// or an empty string if it's not used by any bound PV or pending PVC.
+func (c *ManilaController) isStorageClassInUse(scName string) (string, error) {
+	pvs, err := c.pvLister.List(labels.Everything())
+	if err != nil {
+		return "", err
+	}
+	for _, pv := range pvs {
+		if pv.Spec.StorageClassName == scName && pv.Status.Phase == corev1.VolumeBound {
+			return fmt.Sprintf("PV %s is bound", pv.Name), nil
+		}
+	}
+
+	pvcs, err := c.pvcLister.List(labels.Everything())
+	if err != nil {
+		return "", err
+	}
+	for _, pvc := range pvcs {
+		if pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName == scName && pvc.Status.Phase == corev1.ClaimPending {
+			return fmt.Sprintf("PVC %s/%s is pending", pvc.Namespace, pvc.Name), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,193 @@
+package manila
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+	operatorlisters "github.com/openshift/client-go/operator/listers/operator/v1"
+	"github.com/openshift/csi-driver-manila-operator/pkg/util"
+	"github.com/openshift/library-go/pkg/operator/csi/csistorageclasscontroller"
+	"github.com/openshift/library-go/pkg/operator/events"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestController(objs ...runtime.Object) *ManilaController {
+	scIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pvcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	csiDriverIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, obj := range objs {
+		switch obj.(type) {
+		case *storagev1.StorageClass:
+			scIndexer.Add(obj)
+		case *corev1.PersistentVolume:
+			pvIndexer.Add(obj)
+		case *corev1.PersistentVolumeClaim:
+			pvcIndexer.Add(obj)
+		case *corev1.Node:
+			nodeIndexer.Add(obj)
+		case *storagev1.CSIDriver:
+			csiDriverIndexer.Add(obj)
+		}
+	}
+	kubeClient := fake.NewSimpleClientset(objs...)
+	recorder := events.NewInMemoryRecorder("test")
+	return &ManilaController{
+		kubeClient:         kubeClient,
+		storageClassLister: storagelisters.NewStorageClassLister(scIndexer),
+		csiDriverLister:    storagelisters.NewCSIDriverLister(csiDriverIndexer),
+		pvLister:           corelisters.NewPersistentVolumeLister(pvIndexer),
+		pvcLister:          corelisters.NewPersistentVolumeClaimLister(pvcIndexer),
+		nodeLister:         corelisters.NewNodeLister(nodeIndexer),
+		eventRecorder:      recorder,
+		scStateEvaluator:   newTestStorageClassStateEvaluator(kubeClient, recorder, ""),
+	}
+}
+
+// newTestStorageClassStateEvaluator returns evaluator of ClusterCSIDriver
+// with the given StorageClassState.
+func newTestStorageClassStateEvaluator(kubeClient kubernetes.Interface, recorder events.Recorder, scState operatorv1.StorageClassStateName) *csistorageclasscontroller.StorageClassStateEvaluator {
+	ccdIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	ccdIndexer.Add(&operatorv1.ClusterCSIDriver{
+		ObjectMeta: metav1.ObjectMeta{Name: string(operatorv1.ManilaCSIDriver)},
+		Spec:       operatorv1.ClusterCSIDriverSpec{StorageClassState: scState},
+	})
+	return csistorageclasscontroller.NewStorageClassStateEvaluator(kubeClient, operatorlisters.NewClusterCSIDriverLister(ccdIndexer), recorder)
+}
+
+func generatedSC(name, shareTypeID, missingSince string) *storagev1.StorageClass {
+	sc := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{util.ShareTypeIDLabel: shareTypeID},
+		},
+		Provisioner: util.ManilaDriverName,
+	}
+	if missingSince != "" {
+		sc.Annotations = map[string]string{util.ShareTypeMissingSinceAnnotation: missingSince}
+	}
+	return sc
+}
+
+func TestRemoveStaleStorageClasses(t *testing.T) {
+	fakeNow := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return fakeNow }
+	defer func() { now = time.Now }()
+
+	recent := fakeNow.Add(-time.Hour).Format(time.RFC3339)
+	expired := fakeNow.Add(-2 * defaultGCGracePeriod).Format(time.RFC3339)
+	scName := "csi-manila-gone"
+
+	for _, tc := range []struct {
+		name                string
+		scState             operatorv1.StorageClassStateName
+		expectedSCs         []*storagev1.StorageClass
+		objects             []runtime.Object
+		expectDeleted       bool
+		expectAnnotation    string
+		expectAnnotationSet bool
+	}{
+		{
+			name:                "first seen missing",
+			scState:             operatorv1.ManagedStorageClass,
+			objects:             []runtime.Object{generatedSC(scName, "id1", "")},
+			expectAnnotation:    fakeNow.Format(time.RFC3339),
+			expectAnnotationSet: true,
+		},
+		{
+			name:                "within grace period",
+			scState:             operatorv1.ManagedStorageClass,
+			objects:             []runtime.Object{generatedSC(scName, "id1", recent)},
+			expectAnnotation:    recent,
+			expectAnnotationSet: true,
+		},
+		{
+			name:          "grace period expired",
+			scState:       operatorv1.ManagedStorageClass,
+			objects:       []runtime.Object{generatedSC(scName, "id1", expired)},
+			expectDeleted: true,
+		},
+		{
+			name:    "grace period expired, bound PV",
+			scState: operatorv1.ManagedStorageClass,
+			objects: []runtime.Object{
+				generatedSC(scName, "id1", expired),
+				&corev1.PersistentVolume{
+					ObjectMeta: metav1.ObjectMeta{Name: "pv1"},
+					Spec:       corev1.PersistentVolumeSpec{StorageClassName: scName},
+					Status:     corev1.PersistentVolumeStatus{Phase: corev1.VolumeBound},
+				},
+			},
+			expectAnnotation:    expired,
+			expectAnnotationSet: true,
+		},
+		{
+			name:    "grace period expired, pending PVC",
+			scState: operatorv1.ManagedStorageClass,
+			objects: []runtime.Object{
+				generatedSC(scName, "id1", expired),
+				&corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "pvc1", Namespace: "default"},
+					Spec:       corev1.PersistentVolumeClaimSpec{StorageClassName: &scName},
+					Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending},
+				},
+			},
+			expectAnnotation:    expired,
+			expectAnnotationSet: true,
+		},
+		{
+			name:        "share type is back",
+			scState:     operatorv1.ManagedStorageClass,
+			expectedSCs: []*storagev1.StorageClass{generatedSC(scName, "id1", "")},
+			objects:     []runtime.Object{generatedSC(scName, "id1", recent)},
+		},
+		{
+			name:                "unmanaged",
+			scState:             operatorv1.UnmanagedStorageClass,
+			objects:             []runtime.Object{generatedSC(scName, "id1", expired)},
+			expectAnnotation:    expired,
+			expectAnnotationSet: true,
+		},
+		{
+			name:          "removed",
+			scState:       operatorv1.RemovedStorageClass,
+			expectedSCs:   []*storagev1.StorageClass{generatedSC(scName, "id1", "")},
+			objects:       []runtime.Object{generatedSC(scName, "id1", "")},
+			expectDeleted: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestController(tc.objects...)
+			if err := c.removeStaleStorageClasses(context.TODO(), tc.expectedSCs, tc.scState); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			sc, err := c.kubeClient.StorageV1().StorageClasses().Get(context.TODO(), scName, metav1.GetOptions{})
+			if tc.expectDeleted {
+				if !errors.IsNotFound(err) {
+					t.Errorf("expected StorageClass to be deleted, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected StorageClass to exist, got %v", err)
+			}
+			annotation, ok := sc.Annotations[util.ShareTypeMissingSinceAnnotation]
+			if ok != tc.expectAnnotationSet || annotation != tc.expectAnnotation {
+				t.Errorf("expected annotation %q (set: %v), got %q (set: %v)", tc.expectAnnotation, tc.expectAnnotationSet, annotation, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package manila
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
+	"github.com/openshift/csi-driver-manila-operator/pkg/util"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+// Length of share type ID hash appended to StorageClass names that would be
+// invalid or would collide with a StorageClass of another share type.
+const storageClassNameHashLength = 8
+
+// storageClassNames are StorageClass names assigned to share types.
+type storageClassNames struct {
+	// Names keyed by share type ID, see storageClassKey.
+	names map[string]string
+	// Share types that got a hash suffix, in form "<share type> (as <StorageClass>)".
+	renamed []string
+	// Share types that did not get any StorageClass name.
+	skipped []string
+}
+
+// generateStorageClassNames assigns a unique StorageClass name to each share
+// type. The name is derived from the share type name (see
+// sanitizeStorageClassName). When the name is not valid or it collides with
+// a name of another share type, a hash of the share type ID is appended.
+//
+// Share types that own a StorageClass with the colliding name already keep
+// it, the others are sorted by their IDs, so the result is stable across
+// syncs and operator restarts.
+func (c *ManilaController) generateStorageClassNames(shareTypes []sharetypes.ShareType) (*storageClassNames, error) {
+	generatedSCs, err := c.listGeneratedStorageClasses()
+	if err != nil {
+		return nil, err
+	}
+	owners := map[string]string{}
+	for _, sc := range generatedSCs {
+		owners[sc.Name] = storageClassKey(sc.Labels[util.ShareTypeIDLabel], sc.Labels[util.ShareProtocolLabel])
+	}
+
+	sorted := append([]sharetypes.ShareType{}, shareTypes...)
+	ownsName := func(shareType sharetypes.ShareType) bool {
+		return owners[sanitizeStorageClassName(shareType.Name)] == shareType.ID
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if ownsName(sorted[i]) != ownsName(sorted[j]) {
+			return ownsName(sorted[i])
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	result := &storageClassNames{names: map[string]string{}}
+	used := sets.New[string]()
+	for _, shareType := range sorted {
+		name := sanitizeStorageClassName(shareType.Name)
+		if isValidStorageClassName(name) && !used.Has(name) {
+			result.names[shareType.ID] = name
+			used.Insert(name)
+			continue
+		}
+
+		hashedName := hashedStorageClassName(shareType)
+		if !isValidStorageClassName(hashedName) || used.Has(hashedName) {
+			klog.V(2).Infof("Skipping share type %s (%s): no valid StorageClass name available", shareType.Name, shareType.ID)
+			result.skipped = append(result.skipped, shareType.Name)
+			continue
+		}
+		klog.V(2).Infof("Using StorageClass name %s for share type %s (%s)", hashedName, shareType.Name, shareType.ID)
+		result.names[shareType.ID] = hashedName
+		result.renamed = append(result.renamed, fmt.Sprintf("%s (as %s)", shareType.Name, hashedName))
+		used.Insert(hashedName)
+	}
+	sort.Strings(result.renamed)
+	sort.Strings(result.skipped)
+	return result, nil
+}
+
+// message returns a human readable report of renamed and skipped share types,
+// or an empty string if there are none.
+func (n *storageClassNames) message() string {
+	var msgs []string
+	if len(n.renamed) > 0 {
+		msgs = append(msgs, fmt.Sprintf("StorageClasses of share types %s got a hash suffix because their names are invalid or collide with another share type", strings.Join(n.renamed, ", ")))
+	}
+	if len(n.skipped) > 0 {
+		msgs = append(msgs, fmt.Sprintf("share types %s were skipped because no valid StorageClass name is available", strings.Join(n.skipped, ", ")))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// sanitizeStorageClassName converts a share type name to a StorageClass name.
+// As per RFC 1123 the StorageClass name must consist of lower case
+// alphanumeric characters, '-' or '.', and each '.' separated part must start
+// and end with an alphanumeric character. All other characters are replaced
+// with '-'. The result may still be too long, see isValidStorageClassName.
+// Empty string is returned when nothing is left from the share type name.
+func sanitizeStorageClassName(shareTypeName string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '-' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(shareTypeName))
+
+	var parts []string
+	for _, part := range strings.Split(util.StorageClassNamePrefix+name, ".") {
+		if part = strings.Trim(part, "-"); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	name = strings.Join(parts, ".")
+	if len(name) <= len(strings.TrimRight(util.StorageClassNamePrefix, "-")) {
+		return ""
+	}
+	return name
+}
+
+// hashedStorageClassName returns sanitized name of the share type with a hash
+// of the share type ID appended, truncated to fit the maximum StorageClass
+// name length.
+func hashedStorageClassName(shareType sharetypes.ShareType) string {
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(shareType.ID)))[:storageClassNameHashLength]
+	name := sanitizeStorageClassName(shareType.Name)
+	if name == "" {
+		return util.StorageClassNamePrefix + hash
+	}
+	if maxLength := validation.DNS1123SubdomainMaxLength - len(hash) - 1; len(name) > maxLength {
+		name = strings.TrimRight(name[:maxLength], "-.")
+	}
+	return name + "-" + hash
+}
+
+func isValidStorageClassName(name string) bool {
+	return name != "" && len(validation.IsDNS1123Subdomain(name)) == 0
+}
@@ -0,0 +1,130 @@
+package manila
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestSanitizeStorageClassName(t *testing.T) {
+	for _, tc := range []struct {
+		shareType string
+		expected  string
+	}{
+		{shareType: "default", expected: "csi-manila-default"},
+		{shareType: "Gold_NFS", expected: "csi-manila-gold-nfs"},
+		{shareType: "gold nfs", expected: "csi-manila-gold-nfs"},
+		{shareType: "gold.nfs", expected: "csi-manila-gold.nfs"},
+		{shareType: "gold.", expected: "csi-manila-gold"},
+		{shareType: ".gold-.-nfs_", expected: "csi-manila.gold.nfs"},
+		{shareType: "zlatý", expected: "csi-manila-zlat"},
+		{shareType: "___", expected: ""},
+		{shareType: "", expected: ""},
+	} {
+		t.Run(tc.shareType, func(t *testing.T) {
+			name := sanitizeStorageClassName(tc.shareType)
+			if name != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, name)
+			}
+		})
+	}
+}
+
+func TestGenerateStorageClassNames(t *testing.T) {
+	longName := strings.Repeat("a", 300)
+	hash1 := hashedStorageClassName(sharetypes.ShareType{ID: "id1"})[len("csi-manila-"):]
+	hash2 := hashedStorageClassName(sharetypes.ShareType{ID: "id2"})[len("csi-manila-"):]
+
+	for _, tc := range []struct {
+		name            string
+		shareTypes      []sharetypes.ShareType
+		objects         []runtime.Object
+		expectedNames   map[string]string
+		expectedRenamed []string
+	}{
+		{
+			name: "no collision",
+			shareTypes: []sharetypes.ShareType{
+				{ID: "id1", Name: "gold"},
+				{ID: "id2", Name: "silver"},
+			},
+			expectedNames: map[string]string{
+				"id1": "csi-manila-gold",
+				"id2": "csi-manila-silver",
+			},
+		},
+		{
+			name: "collision",
+			shareTypes: []sharetypes.ShareType{
+				{ID: "id2", Name: "Gold_NFS"},
+				{ID: "id1", Name: "gold-nfs"},
+			},
+			expectedNames: map[string]string{
+				"id1": "csi-manila-gold-nfs",
+				"id2": "csi-manila-gold-nfs-" + hash2,
+			},
+			expectedRenamed: []string{"Gold_NFS (as csi-manila-gold-nfs-" + hash2 + ")"},
+		},
+		{
+			name: "collision with existing StorageClass",
+			shareTypes: []sharetypes.ShareType{
+				{ID: "id2", Name: "Gold_NFS"},
+				{ID: "id1", Name: "gold-nfs"},
+			},
+			objects: []runtime.Object{generatedSC("csi-manila-gold-nfs", "id2", "")},
+			expectedNames: map[string]string{
+				"id1": "csi-manila-gold-nfs-" + hash1,
+				"id2": "csi-manila-gold-nfs",
+			},
+			expectedRenamed: []string{"gold-nfs (as csi-manila-gold-nfs-" + hash1 + ")"},
+		},
+		{
+			name: "CephFS",
+			shareTypes: []sharetypes.ShareType{
+				protocolShareType(sharetypes.ShareType{ID: "id1", Name: "gold"}, shareProtocolNFS),
+				protocolShareType(sharetypes.ShareType{ID: "id1", Name: "gold"}, shareProtocolCephFS),
+			},
+			expectedNames: map[string]string{
+				"id1":        "csi-manila-gold",
+				"id1-cephfs": "csi-manila-gold-cephfs",
+			},
+		},
+		{
+			name: "invalid names",
+			shareTypes: []sharetypes.ShareType{
+				{ID: "id1", Name: "___"},
+				{ID: "id2", Name: longName},
+			},
+			expectedNames: map[string]string{
+				"id1": "csi-manila-" + hash1,
+				"id2": "csi-manila-" + longName[:253-len("csi-manila-")-9] + "-" + hash2,
+			},
+			expectedRenamed: []string{
+				"___ (as csi-manila-" + hash1 + ")",
+				longName + " (as csi-manila-" + longName[:253-len("csi-manila-")-9] + "-" + hash2 + ")",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestController(tc.objects...)
+			names, err := c.generateStorageClassNames(tc.shareTypes)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(names.names, tc.expectedNames) {
+				t.Errorf("expected names %v, got %v", tc.expectedNames, names.names)
+			}
+			if !reflect.DeepEqual(names.renamed, tc.expectedRenamed) {
+				t.Errorf("expected renamed %v, got %v", tc.expectedRenamed, names.renamed)
+			}
+			for _, name := range names.names {
+				if !isValidStorageClassName(name) {
+					t.Errorf("invalid StorageClass name %q", name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,387 @@
+package manila
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/csi-driver-manila-operator/assets"
+	"github.com/openshift/csi-driver-manila-operator/pkg/util"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceapply"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceread"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	k8serrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// Deployments of the driver instances.
+	controllerAssets = []string{"controller.yaml", "cephfs/controller.yaml"}
+	// DaemonSets of the driver instances and the drivers they forward to.
+	nodeAssets = []string{"node.yaml", "node_nfs.yaml", "cephfs/node.yaml", "cephfs/node_cephfs.yaml"}
+	// CSIDrivers of the driver instances.
+	csiDriverAssets = []string{"csidriver.yaml", "cephfs/csidriver.yaml"}
+
+	// Operands of the CephFS driver instance, removed when Manila stops
+	// offering CephFS.
+	cephFSControllerAssets = []string{"cephfs/controller.yaml"}
+	cephFSNodeAssets       = []string{"cephfs/node.yaml", "cephfs/node_cephfs.yaml"}
+	cephFSPDBAsset         = "cephfs/controller_pdb.yaml"
+	cephFSCSIDriverAsset   = "cephfs/csidriver.yaml"
+	// Prefixes of conditions and finalizers of the CephFS driver controllers.
+	cephFSControllerPrefixes = []string{"ManilaCephFSDriver", "CephFSDriverNodeServiceController"}
+)
+
+// Infix of finalizers added to ClusterCSIDriver by library-go controllers,
+// "<operator name>.operator.openshift.io/<controller name>".
+const finalizerInfix = ".operator.openshift.io/"
+
+// Prefixes of conditions of the driver controllers started by
+// ManilaController, i.e. names of the controllers in pkg/operator/starter.go
+// and of fixed conditions of library-go controllers.
+var operandConditionPrefixes = []string{
+	"ManilaDriver",
+	"ManilaCephFSDriver",
+	"NFSDriverNodeServiceController",
+	"CephFSDriverNodeServiceController",
+	"SecretSync",
+	"ResourceSyncController",
+	"ManagementState",
+}
+
+// removeOperands stops the driver controllers and deletes everything they and
+// ManilaController created, in order: Deployments, DaemonSets, CSIDrivers,
+// generated StorageClasses, VolumeSnapshotClasses and the driver secret.
+// Finalizers and conditions of the stopped controllers are removed too.
+// Deletion of an object that does not exist is not an error, so it's safe to
+// call it in every sync.
+//
+// Shares and share networks in Manila are never deleted.
+func (c *ManilaController) removeOperands(ctx context.Context, reason string) error {
+	if c.stopCSIControllers != nil {
+		klog.V(2).Infof("Stopping CSI driver controllers: %s", reason)
+		c.stopCSIControllers()
+		c.stopCSIControllers = nil
+	}
+	if c.stopCephFSControllers != nil {
+		klog.V(2).Infof("Stopping CephFS CSI driver controllers: %s", reason)
+		c.stopCephFSControllers()
+		c.stopCephFSControllers = nil
+	}
+
+	// Stop at the first failed step, so e.g. the CSIDriver is not removed
+	// while the driver may be still running.
+	for _, remove := range []func(context.Context) error{
+		c.removeDeployments,
+		c.removeDaemonSets,
+		c.removeCSIDrivers,
+		c.removeStorageClasses,
+		c.removeVolumeSnapshotClasses,
+		c.removeDriverSecret,
+		c.removeFinalizers,
+		c.removeOperandConditions,
+	} {
+		if err := remove(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ManilaController) removeDeployments(ctx context.Context) error {
+	return c.removeDeploymentAssets(ctx, controllerAssets)
+}
+
+func (c *ManilaController) removeDeploymentAssets(ctx context.Context, assetNames []string) error {
+	for _, assetName := range assetNames {
+		deployment := resourceread.ReadDeploymentV1OrDie(mustReadAsset(assetName))
+		err := c.kubeClient.AppsV1().Deployments(deployment.Namespace).Delete(ctx, deployment.Name, metav1.DeleteOptions{})
+		if err := c.reportDeletion(err, "Deployment", deployment.Namespace+"/"+deployment.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ManilaController) removeDaemonSets(ctx context.Context) error {
+	return c.removeDaemonSetAssets(ctx, nodeAssets)
+}
+
+func (c *ManilaController) removeDaemonSetAssets(ctx context.Context, assetNames []string) error {
+	for _, assetName := range assetNames {
+		ds := resourceread.ReadDaemonSetV1OrDie(mustReadAsset(assetName))
+		err := c.kubeClient.AppsV1().DaemonSets(ds.Namespace).Delete(ctx, ds.Name, metav1.DeleteOptions{})
+		if err := c.reportDeletion(err, "DaemonSet", ds.Namespace+"/"+ds.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ManilaController) removeCSIDrivers(ctx context.Context) error {
+	return c.removeCSIDriverAssets(ctx, csiDriverAssets)
+}
+
+func (c *ManilaController) removeCSIDriverAssets(ctx context.Context, assetNames []string) error {
+	for _, assetName := range assetNames {
+		csiDriver := resourceread.ReadCSIDriverV1OrDie(mustReadAsset(assetName))
+		if _, _, err := resourceapply.DeleteCSIDriver(ctx, c.kubeClient.StorageV1(), c.eventRecorder, csiDriver); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStorageClasses deletes all generated StorageClasses, regardless of
+// PVs that use them. Nothing is deleted when StorageClassState is Unmanaged.
+func (c *ManilaController) removeStorageClasses(ctx context.Context) error {
+	if c.storageClassesUnmanaged() {
+		klog.V(2).Infof("Keeping StorageClasses, StorageClassState is %s", operatorv1.UnmanagedStorageClass)
+		return nil
+	}
+	generatedSCs, err := c.listGeneratedStorageClasses()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, sc := range generatedSCs {
+		if _, _, err := resourceapply.DeleteStorageClass(ctx, c.kubeClient.StorageV1(), c.eventRecorder, sc); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return k8serrors.NewAggregate(errs)
+}
+
+// removeVolumeSnapshotClasses deletes the generated VolumeSnapshotClasses and
+// the legacy one. Like StorageClasses, they're kept when StorageClassState is
+// Unmanaged.
+func (c *ManilaController) removeVolumeSnapshotClasses(ctx context.Context) error {
+	if !c.volumeSnapshotCRDExists() || c.storageClassesUnmanaged() {
+		return nil
+	}
+	vscList, err := c.dynamicClient.Resource(volumeSnapshotClassGVR).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i := range vscList.Items {
+		vsc := &vscList.Items[i]
+		driver, _, _ := unstructured.NestedString(vsc.Object, "driver")
+		_, generated := vsc.GetLabels()[util.ShareTypeIDLabel]
+		if !isManilaDriver(driver) || (!generated && vsc.GetName() != legacyVolumeSnapshotClassName) {
+			continue
+		}
+		if _, _, err := resourceapply.DeleteVolumeSnapshotClass(ctx, c.dynamicClient, c.eventRecorder, vsc); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return k8serrors.NewAggregate(errs)
+}
+
+func (c *ManilaController) storageClassesUnmanaged() bool {
+	return c.scStateEvaluator.GetStorageClassState(string(operatorv1.ManilaCSIDriver)) == operatorv1.UnmanagedStorageClass
+}
+
+func (c *ManilaController) removeDriverSecret(ctx context.Context) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      util.ManilaSecretName,
+			Namespace: util.OperandNamespace,
+		},
+	}
+	_, _, err := resourceapply.DeleteSecret(ctx, c.kubeClient.CoreV1(), c.eventRecorder, secret)
+	return err
+}
+
+// removeFinalizers removes finalizers that the stopped library-go controllers
+// added to ClusterCSIDriver. They would remove them only after deleting their
+// operands, which ManilaController did instead.
+func (c *ManilaController) removeFinalizers(ctx context.Context) error {
+	return c.removeFinalizersWithPrefixes(ctx, []string{""})
+}
+
+// removeFinalizersWithPrefixes removes finalizers of library-go controllers
+// whose names start with any of the prefixes.
+func (c *ManilaController) removeFinalizersWithPrefixes(ctx context.Context, prefixes []string) error {
+	meta, err := c.operatorClient.GetObjectMeta()
+	if err != nil {
+		return err
+	}
+	for _, finalizer := range meta.Finalizers {
+		_, controller, found := strings.Cut(finalizer, finalizerInfix)
+		if !found || !hasAnyPrefix(controller, prefixes) {
+			continue
+		}
+		if err := c.operatorClient.RemoveFinalizer(ctx, finalizer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeOperandConditions removes conditions of the stopped driver
+// controllers, e.g. a stale Degraded condition of a DaemonSet controller.
+func (c *ManilaController) removeOperandConditions(ctx context.Context) error {
+	return c.removeConditionsWithPrefixes(ctx, operandConditionPrefixes)
+}
+
+func (c *ManilaController) removeConditionsWithPrefixes(ctx context.Context, prefixes []string) error {
+	_, status, _, err := c.operatorClient.GetOperatorState()
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for _, cnd := range status.Conditions {
+		if hasAnyPrefix(cnd.Type, prefixes) {
+			stale = append(stale, cnd.Type)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	klog.V(2).Infof("Removing conditions of stopped driver controllers: %s", strings.Join(stale, ", "))
+	_, _, err = v1helpers.UpdateStatus(ctx, c.operatorClient, func(status *operatorv1.OperatorStatus) error {
+		for _, cndType := range stale {
+			v1helpers.RemoveOperatorCondition(&status.Conditions, cndType)
+		}
+		return nil
+	})
+	return err
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeCephFSDriverIfUnused stops the CephFS driver controllers and removes
+// the CephFS driver when Manila does not offer CephFS anymore and nothing uses
+// the driver, i.e. no PV was provisioned by it and no StorageClass provisions
+// with it. Generated CephFS StorageClasses are garbage collected only after
+// their grace period, so the driver stays at least that long.
+func (c *ManilaController) removeCephFSDriverIfUnused(ctx context.Context, protocols sets.Set[string]) error {
+	if protocols.Has(shareProtocolCephFS) {
+		return nil
+	}
+	csiDriver := resourceread.ReadCSIDriverV1OrDie(mustReadAsset(cephFSCSIDriverAsset))
+	if _, err := c.csiDriverLister.Get(csiDriver.Name); errors.IsNotFound(err) && c.stopCephFSControllers == nil {
+		// Not installed.
+		return nil
+	}
+	inUse, err := c.cephFSDriverInUse()
+	if err != nil || inUse {
+		return err
+	}
+
+	if c.stopCephFSControllers != nil {
+		klog.V(2).Infof("Stopping CephFS CSI driver controllers: Manila does not offer CephFS")
+		c.stopCephFSControllers()
+		c.stopCephFSControllers = nil
+	}
+	// The CSIDriver goes last, it marks the driver as installed.
+	for _, remove := range []func(context.Context) error{
+		func(ctx context.Context) error { return c.removeDeploymentAssets(ctx, cephFSControllerAssets) },
+		func(ctx context.Context) error { return c.removeDaemonSetAssets(ctx, cephFSNodeAssets) },
+		c.removeCephFSPDB,
+		func(ctx context.Context) error { return c.removeFinalizersWithPrefixes(ctx, cephFSControllerPrefixes) },
+		func(ctx context.Context) error { return c.removeConditionsWithPrefixes(ctx, cephFSControllerPrefixes) },
+		func(ctx context.Context) error { return c.removeCSIDriverAssets(ctx, []string{cephFSCSIDriverAsset}) },
+	} {
+		if err := remove(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cephFSDriverInUse returns true when a PV or StorageClass uses the CephFS
+// driver.
+func (c *ManilaController) cephFSDriverInUse() (bool, error) {
+	scs, err := c.storageClassLister.List(labels.Everything())
+	if err != nil {
+		return false, err
+	}
+	for _, sc := range scs {
+		if sc.Provisioner == util.ManilaCephFSDriverName {
+			klog.V(4).Infof("Keeping CephFS CSI driver, StorageClass %s uses it", sc.Name)
+			return true, nil
+		}
+	}
+	pvs, err := c.pvLister.List(labels.Everything())
+	if err != nil {
+		return false, err
+	}
+	for _, pv := range pvs {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == util.ManilaCephFSDriverName {
+			klog.V(4).Infof("Keeping CephFS CSI driver, PersistentVolume %s uses it", pv.Name)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *ManilaController) removeCephFSPDB(ctx context.Context) error {
+	pdb := resourceread.ReadPodDisruptionBudgetV1OrDie(mustReadAsset(cephFSPDBAsset))
+	err := c.kubeClient.PolicyV1().PodDisruptionBudgets(pdb.Namespace).Delete(ctx, pdb.Name, metav1.DeleteOptions{})
+	return c.reportDeletion(err, "PodDisruptionBudget", pdb.Namespace+"/"+pdb.Name)
+}
+
+// reportDeletion emits an event about a deleted object. NotFound error is
+// ignored, the object has been already deleted.
+func (c *ManilaController) reportDeletion(err error, kind, name string) error {
+	switch {
+	case errors.IsNotFound(err):
+		return nil
+	case err != nil:
+		c.eventRecorder.Warningf(kind+"DeleteFailed", "Failed to delete %s %s: %v", kind, name, err)
+		return err
+	}
+	klog.V(2).Infof("Deleted %s %s", kind, name)
+	c.eventRecorder.Eventf(kind+"Deleted", "Deleted %s", name)
+	return nil
+}
+
+// uninstallIfManilaGone removes the operands when uninstallAfterManilaGone is
+// configured and the controller has been Disabled because of missing Manila
+// endpoint for longer than that. Time of the Disabled condition transition
+// is used, so it survives operator restarts.
+func (c *ManilaController) uninstallIfManilaGone(ctx context.Context) error {
+	cfg, err := c.getOperatorConfig()
+	if err != nil || cfg.UninstallAfterManilaGone == nil {
+		return nil
+	}
+	_, status, _, err := c.operatorClient.GetOperatorState()
+	if err != nil {
+		return err
+	}
+	cnd := v1helpers.FindOperatorCondition(status.Conditions, disabledCondition)
+	if cnd == nil || cnd.Status != operatorv1.ConditionTrue || cnd.Reason != reasonEndpointNotFound {
+		return nil
+	}
+	gone := now().Sub(cnd.LastTransitionTime.Time)
+	if gone < cfg.UninstallAfterManilaGone.Duration {
+		klog.V(4).Infof("Manila is missing for %s, the driver will be uninstalled after %s", gone.Round(time.Second), cfg.UninstallAfterManilaGone.Duration)
+		return nil
+	}
+	return c.removeOperands(ctx, fmt.Sprintf("Manila is missing since %s", cnd.LastTransitionTime.UTC().Format(time.RFC3339)))
+}
+
+func mustReadAsset(name string) []byte {
+	stream, err := assets.ReadFile(name)
+	if err != nil {
+		panic(fmt.Sprintf("Error loading asset %s: %v", name, err))
+	}
+	return stream
+}
@@ -0,0 +1,309 @@
+package manila
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/csi-driver-manila-operator/pkg/util"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceread"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestRemoveOperands(t *testing.T) {
+	userSC := &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: "user-sc"},
+		Provisioner: util.ManilaDriverName,
+	}
+	c := newTestController(
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "openstack-manila-csi-controllerplugin", Namespace: util.OperandNamespace}},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "csi-nodeplugin-nfsplugin", Namespace: util.OperandNamespace}},
+		&storagev1.CSIDriver{ObjectMeta: metav1.ObjectMeta{Name: util.ManilaDriverName}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: util.ManilaSecretName, Namespace: util.OperandNamespace}},
+		generatedSC("csi-manila-gold", "id1", ""),
+		userSC,
+	)
+	c.volumeSnapshotCRDExists = func() bool { return false }
+	c.operatorClient = v1helpers.NewFakeOperatorClientWithObjectMeta(&metav1.ObjectMeta{
+		Finalizers: []string{
+			"manila-csi-driver-operator.operator.openshift.io/ManilaDriverNodeServiceController",
+			"example.com/other",
+		},
+	}, &operatorv1.OperatorSpec{}, &operatorv1.OperatorStatus{
+		Conditions: []operatorv1.OperatorCondition{
+			{Type: "ManilaDriverNodeServiceControllerAvailable", Status: operatorv1.ConditionTrue},
+			{Type: "ManilaDriverControllerServiceControllerDegraded", Status: operatorv1.ConditionTrue},
+			{Type: "NFSDriverNodeServiceControllerProgressing", Status: operatorv1.ConditionFalse},
+			{Type: "SecretSyncCredentialsDegraded", Status: operatorv1.ConditionTrue},
+			{Type: "ManagementStateDegraded", Status: operatorv1.ConditionFalse},
+			{Type: disabledCondition, Status: operatorv1.ConditionFalse},
+			{Type: "ManilaOperatorMonitoringStaticResourcesDegraded", Status: operatorv1.ConditionFalse},
+		},
+	}, nil)
+	stopped := false
+	c.stopCSIControllers = func() { stopped = true }
+
+	if err := c.removeOperands(context.TODO(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !stopped || c.stopCSIControllers != nil {
+		t.Errorf("expected CSI driver controllers to be stopped")
+	}
+
+	ctx := context.TODO()
+	if _, err := c.kubeClient.AppsV1().Deployments(util.OperandNamespace).Get(ctx, "openstack-manila-csi-controllerplugin", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected Deployment to be deleted, got %v", err)
+	}
+	if _, err := c.kubeClient.AppsV1().DaemonSets(util.OperandNamespace).Get(ctx, "csi-nodeplugin-nfsplugin", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected DaemonSet to be deleted, got %v", err)
+	}
+	if _, err := c.kubeClient.StorageV1().CSIDrivers().Get(ctx, util.ManilaDriverName, metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected CSIDriver to be deleted, got %v", err)
+	}
+	if _, err := c.kubeClient.CoreV1().Secrets(util.OperandNamespace).Get(ctx, util.ManilaSecretName, metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected Secret to be deleted, got %v", err)
+	}
+	if _, err := c.kubeClient.StorageV1().StorageClasses().Get(ctx, "csi-manila-gold", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected generated StorageClass to be deleted, got %v", err)
+	}
+	if _, err := c.kubeClient.StorageV1().StorageClasses().Get(ctx, userSC.Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected StorageClass %s to be kept, got %v", userSC.Name, err)
+	}
+	meta, _ := c.operatorClient.GetObjectMeta()
+	if len(meta.Finalizers) != 1 || meta.Finalizers[0] != "example.com/other" {
+		t.Errorf("expected only foreign finalizer to be kept, got %v", meta.Finalizers)
+	}
+	_, status, _, _ := c.operatorClient.GetOperatorState()
+	var conditions []string
+	for _, cnd := range status.Conditions {
+		conditions = append(conditions, cnd.Type)
+	}
+	if len(conditions) != 2 || conditions[0] != disabledCondition || conditions[1] != "ManilaOperatorMonitoringStaticResourcesDegraded" {
+		t.Errorf("expected only conditions of the operator controllers to be kept, got %v", conditions)
+	}
+
+	// Second call finds nothing to delete.
+	if err := c.removeOperands(ctx, "test"); err != nil {
+		t.Errorf("unexpected error on repeated removal: %v", err)
+	}
+}
+
+func TestRemoveOperandsUnmanagedStorageClasses(t *testing.T) {
+	c := newTestController(generatedSC("csi-manila-gold", "id1", ""))
+	c.scStateEvaluator = newTestStorageClassStateEvaluator(c.kubeClient, c.eventRecorder, operatorv1.UnmanagedStorageClass)
+	c.volumeSnapshotCRDExists = func() bool { return true }
+	c.operatorClient = v1helpers.NewFakeOperatorClient(&operatorv1.OperatorSpec{}, &operatorv1.OperatorStatus{}, nil)
+
+	// VolumeSnapshotClasses are not listed, there's no dynamic client.
+	if err := c.removeOperands(context.TODO(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.kubeClient.StorageV1().StorageClasses().Get(context.TODO(), "csi-manila-gold", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected StorageClass to be kept with Unmanaged StorageClassState, got %v", err)
+	}
+}
+
+func TestStartControllers(t *testing.T) {
+	exited := false
+	stop, err := startControllers(context.TODO(), func() ([]Runnable, error) {
+		return []Runnable{runnableFunc(func(ctx context.Context, workers int) {
+			<-ctx.Done()
+			// A sync in progress.
+			time.Sleep(50 * time.Millisecond)
+			exited = true
+		})}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stop()
+	if !exited {
+		t.Errorf("expected the controllers to exit before stop returns")
+	}
+}
+
+type runnableFunc func(ctx context.Context, workers int)
+
+func (f runnableFunc) Run(ctx context.Context, workers int) {
+	f(ctx, workers)
+}
+
+func TestUninstallIfManilaGone(t *testing.T) {
+	fakeNow := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return fakeNow }
+	defer func() { now = time.Now }()
+
+	for _, tc := range []struct {
+		name          string
+		config        string
+		reason        string
+		disabledFor   time.Duration
+		expectRemoved bool
+	}{
+		{
+			name:        "not configured",
+			reason:      reasonEndpointNotFound,
+			disabledFor: 1000 * time.Hour,
+		},
+		{
+			name:        "gone shortly",
+			config:      "uninstallAfterManilaGone: 24h",
+			reason:      reasonEndpointNotFound,
+			disabledFor: time.Hour,
+		},
+		{
+			name:          "gone for long",
+			config:        "uninstallAfterManilaGone: 24h",
+			reason:        reasonEndpointNotFound,
+			disabledFor:   25 * time.Hour,
+			expectRemoved: true,
+		},
+		{
+			name:        "disabled for other reason",
+			config:      "uninstallAfterManilaGone: 24h",
+			reason:      "Other",
+			disabledFor: 25 * time.Hour,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestController(&storagev1.CSIDriver{ObjectMeta: metav1.ObjectMeta{Name: util.ManilaDriverName}})
+			c.volumeSnapshotCRDExists = func() bool { return false }
+			cmIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			cmIndexer.Add(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: operatorConfigMapName, Namespace: util.OperatorNamespace},
+				Data:       map[string]string{operatorConfigKey: tc.config},
+			})
+			c.configMapLister = corelisters.NewConfigMapLister(cmIndexer)
+			c.operatorClient = v1helpers.NewFakeOperatorClient(&operatorv1.OperatorSpec{}, &operatorv1.OperatorStatus{
+				Conditions: []operatorv1.OperatorCondition{{
+					Type:               disabledCondition,
+					Status:             operatorv1.ConditionTrue,
+					Reason:             tc.reason,
+					LastTransitionTime: metav1.NewTime(fakeNow.Add(-tc.disabledFor)),
+				}},
+			}, nil)
+
+			if err := c.uninstallIfManilaGone(context.TODO()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, err := c.kubeClient.StorageV1().CSIDrivers().Get(context.TODO(), util.ManilaDriverName, metav1.GetOptions{})
+			if removed := errors.IsNotFound(err); removed != tc.expectRemoved {
+				t.Errorf("expected removed %t, got %t", tc.expectRemoved, removed)
+			}
+		})
+	}
+}
+
+func TestRemoveCephFSDriverIfUnused(t *testing.T) {
+	controller := resourceread.ReadDeploymentV1OrDie(mustReadAsset("cephfs/controller.yaml"))
+	node := resourceread.ReadDaemonSetV1OrDie(mustReadAsset("cephfs/node.yaml"))
+	nfsNode := resourceread.ReadDaemonSetV1OrDie(mustReadAsset("node.yaml"))
+	cephFSPV := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv1"},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: util.ManilaCephFSDriverName, VolumeHandle: "share1"},
+			},
+		},
+	}
+	for _, tc := range []struct {
+		name          string
+		protocols     []string
+		extraObjs     []runtime.Object
+		expectRemoved bool
+	}{
+		{
+			name:      "CephFS offered",
+			protocols: []string{shareProtocolNFS, shareProtocolCephFS},
+		},
+		{
+			name:          "CephFS gone",
+			protocols:     []string{shareProtocolNFS},
+			expectRemoved: true,
+		},
+		{
+			name:      "CephFS gone, PV exists",
+			protocols: []string{shareProtocolNFS},
+			extraObjs: []runtime.Object{cephFSPV},
+		},
+		{
+			name:      "CephFS gone, StorageClass exists",
+			protocols: []string{shareProtocolNFS},
+			extraObjs: []runtime.Object{&storagev1.StorageClass{
+				ObjectMeta:  metav1.ObjectMeta{Name: "csi-manila-gold-cephfs"},
+				Provisioner: util.ManilaCephFSDriverName,
+			}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			objs := append([]runtime.Object{
+				controller.DeepCopy(),
+				node.DeepCopy(),
+				nfsNode.DeepCopy(),
+				&storagev1.CSIDriver{ObjectMeta: metav1.ObjectMeta{Name: util.ManilaCephFSDriverName}},
+			}, tc.extraObjs...)
+			c := newTestController(objs...)
+			c.operatorClient = v1helpers.NewFakeOperatorClientWithObjectMeta(&metav1.ObjectMeta{
+				Finalizers: []string{
+					"manila-csi-driver-operator.operator.openshift.io/ManilaCephFSDriverNodeServiceController",
+					"manila-csi-driver-operator.operator.openshift.io/ManilaDriverNodeServiceController",
+				},
+			}, &operatorv1.OperatorSpec{}, &operatorv1.OperatorStatus{
+				Conditions: []operatorv1.OperatorCondition{
+					{Type: "ManilaCephFSDriverNodeServiceControllerAvailable", Status: operatorv1.ConditionTrue},
+					{Type: "CephFSDriverNodeServiceControllerDegraded", Status: operatorv1.ConditionFalse},
+					{Type: "ManilaDriverNodeServiceControllerAvailable", Status: operatorv1.ConditionTrue},
+				},
+			}, nil)
+			stopped := false
+			c.stopCephFSControllers = func() { stopped = true }
+
+			if err := c.removeCephFSDriverIfUnused(context.TODO(), sets.New(tc.protocols...)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if stopped != tc.expectRemoved {
+				t.Errorf("expected CephFS controllers stopped %t, got %t", tc.expectRemoved, stopped)
+			}
+			ctx := context.TODO()
+			_, err := c.kubeClient.AppsV1().Deployments(controller.Namespace).Get(ctx, controller.Name, metav1.GetOptions{})
+			if removed := errors.IsNotFound(err); removed != tc.expectRemoved {
+				t.Errorf("expected Deployment removed %t, got %t", tc.expectRemoved, removed)
+			}
+			_, err = c.kubeClient.AppsV1().DaemonSets(node.Namespace).Get(ctx, node.Name, metav1.GetOptions{})
+			if removed := errors.IsNotFound(err); removed != tc.expectRemoved {
+				t.Errorf("expected DaemonSet removed %t, got %t", tc.expectRemoved, removed)
+			}
+			_, err = c.kubeClient.StorageV1().CSIDrivers().Get(ctx, util.ManilaCephFSDriverName, metav1.GetOptions{})
+			if removed := errors.IsNotFound(err); removed != tc.expectRemoved {
+				t.Errorf("expected CSIDriver removed %t, got %t", tc.expectRemoved, removed)
+			}
+			// The NFS driver is never touched.
+			if _, err := c.kubeClient.AppsV1().DaemonSets(nfsNode.Namespace).Get(ctx, nfsNode.Name, metav1.GetOptions{}); err != nil {
+				t.Errorf("expected NFS DaemonSet to be kept, got %v", err)
+			}
+
+			meta, _ := c.operatorClient.GetObjectMeta()
+			_, status, _, _ := c.operatorClient.GetOperatorState()
+			expectedFinalizers, expectedConditions := 2, 3
+			if tc.expectRemoved {
+				expectedFinalizers, expectedConditions = 1, 1
+			}
+			if len(meta.Finalizers) != expectedFinalizers {
+				t.Errorf("expected %d finalizers, got %v", expectedFinalizers, meta.Finalizers)
+			}
+			if len(status.Conditions) != expectedConditions {
+				t.Errorf("expected %d conditions, got %v", expectedConditions, status.Conditions)
+			}
+		})
+	}
+}
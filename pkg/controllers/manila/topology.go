@@ -0,0 +1,100 @@
+package manila
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Topology key reported by the CSI driver started with --with-topology. Its
+// value is the Nova availability zone of the node.
+const manilaTopologyKey = "topology.manila.csi.openstack.org/zone"
+
+// StorageClass parameter of the CSI driver with Manila availability zone of
+// new shares.
+const availabilityParameter = "availability"
+
+// Condition reporting share types skipped because none of their availability
+// zones has any node. Their StorageClasses are garbage collected.
+const shareTypeZonesUnavailableCondition = operatorConditionPrefix + "ShareTypeZonesUnavailable"
+
+// getNodeZones returns availability zones of the cluster nodes, as found in
+// their topology.kubernetes.io/zone labels.
+func (c *ManilaController) getNodeZones() (sets.Set[string], error) {
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	zones := sets.New[string]()
+	for _, node := range nodes {
+		if zone := node.Labels[corev1.LabelTopologyZone]; zone != "" {
+			zones.Insert(zone)
+		}
+	}
+	return zones, nil
+}
+
+// getShareTypeZones returns availability zones where shares of a share type
+// restricted to availabilityZones can be provisioned and consumed by the
+// cluster, i.e. zones present both in the share type and on the nodes. Nova
+// and Manila availability zones are matched by name. When no node has a
+// zone label, the share type zones are returned as they are.
+func getShareTypeZones(availabilityZones []string, nodeZones sets.Set[string]) []string {
+	if nodeZones.Len() == 0 {
+		return availabilityZones
+	}
+	return sets.List(sets.New(availabilityZones...).Intersection(nodeZones))
+}
+
+// applyTopology restricts the StorageClass of a share type available in a
+// single zone to that zone and delays binding until a pod is scheduled. The
+// driver does not choose the Manila availability zone from the pod topology,
+// it must be set in the StorageClass. With more zones, Manila picks any of the
+// share type zones, so the StorageClass is not restricted at all, allowed
+// topologies of all the zones would not keep the pod in the zone of the
+// share.
+func applyTopology(sc *storagev1.StorageClass, zones []string) {
+	if len(zones) != 1 {
+		return
+	}
+	sc.Parameters[availabilityParameter] = zones[0]
+	sc.AllowedTopologies = []corev1.TopologySelectorTerm{
+		{
+			MatchLabelExpressions: []corev1.TopologySelectorLabelRequirement{
+				{
+					Key:    manilaTopologyKey,
+					Values: zones,
+				},
+			},
+		},
+	}
+	bindingMode := storagev1.VolumeBindingWaitForFirstConsumer
+	sc.VolumeBindingMode = &bindingMode
+}
+
+// reportUnavailableZones reports share types skipped because none of their
+// availability zones has any node in a condition. An event is emitted when
+// the report changes.
+func (c *ManilaController) reportUnavailableZones(ctx context.Context, skipped []string) error {
+	var msg string
+	if len(skipped) > 0 {
+		msg = fmt.Sprintf("No StorageClass for share types %s, none of their availability zones has any node", strings.Join(skipped, ", "))
+	}
+	_, status, _, err := c.operatorClient.GetOperatorState()
+	if err != nil {
+		return err
+	}
+	if msg != "" {
+		existing := v1helpers.FindOperatorCondition(status.Conditions, shareTypeZonesUnavailableCondition)
+		if existing == nil || existing.Message != msg {
+			c.eventRecorder.Warningf("ShareTypeZonesUnavailable", "%s", msg)
+		}
+	}
+	return c.updateCondition(ctx, shareTypeZonesUnavailableCondition, "NoNodesInZones", msg)
+}
@@ -0,0 +1,128 @@
+package manila
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestGetShareTypeZones(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		shareType []string
+		nodes     []string
+		expected  []string
+	}{
+		{
+			name:      "no zone labels on nodes",
+			shareType: []string{"az2", "az1"},
+			expected:  []string{"az2", "az1"},
+		},
+		{
+			name:      "intersection",
+			shareType: []string{"az3", "az1", "az2"},
+			nodes:     []string{"az1", "az2", "az4"},
+			expected:  []string{"az1", "az2"},
+		},
+		{
+			name:      "no common zone",
+			shareType: []string{"az3"},
+			nodes:     []string{"az1"},
+			expected:  []string{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			zones := getShareTypeZones(tc.shareType, sets.New(tc.nodes...))
+			if !reflect.DeepEqual(zones, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, zones)
+			}
+		})
+	}
+}
+
+func TestGetNodeZones(t *testing.T) {
+	c := newTestController(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{corev1.LabelTopologyZone: "az1"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{corev1.LabelTopologyZone: "az2"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node3", Labels: map[string]string{corev1.LabelTopologyZone: "az1"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node4"}},
+	)
+	zones, err := c.getNodeZones()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !zones.Equal(sets.New("az1", "az2")) {
+		t.Errorf("unexpected zones %v", sets.List(zones))
+	}
+}
+
+func TestApplyTopology(t *testing.T) {
+	c := newTestController()
+	shareType := sharetypes.ShareType{ID: "id1", Name: "gold"}
+
+	sc := c.generateStorageClass(shareType, shareProtocolNFS, "csi-manila-gold")
+	applyTopology(sc, nil)
+	if sc.AllowedTopologies != nil || *sc.VolumeBindingMode != storagev1.VolumeBindingImmediate {
+		t.Errorf("unexpected topology of StorageClass without zones: %+v", sc)
+	}
+
+	sc = c.generateStorageClass(shareType, shareProtocolNFS, "csi-manila-gold")
+	applyTopology(sc, []string{"az1"})
+	if sc.Parameters[availabilityParameter] != "az1" {
+		t.Errorf("expected availability az1, got %q", sc.Parameters[availabilityParameter])
+	}
+	if *sc.VolumeBindingMode != storagev1.VolumeBindingWaitForFirstConsumer {
+		t.Errorf("unexpected volumeBindingMode %s", *sc.VolumeBindingMode)
+	}
+
+	if len(sc.AllowedTopologies) != 1 || !reflect.DeepEqual(sc.AllowedTopologies[0].MatchLabelExpressions[0].Values, []string{"az1"}) {
+		t.Errorf("unexpected allowedTopologies %+v", sc.AllowedTopologies)
+	}
+
+	// Manila may put the share in any of the zones, pods are not restricted.
+	sc = c.generateStorageClass(shareType, shareProtocolNFS, "csi-manila-gold")
+	applyTopology(sc, []string{"az1", "az2"})
+	if _, ok := sc.Parameters[availabilityParameter]; ok {
+		t.Errorf("unexpected availability parameter with multiple zones")
+	}
+	if sc.AllowedTopologies != nil || *sc.VolumeBindingMode != storagev1.VolumeBindingImmediate {
+		t.Errorf("unexpected topology of StorageClass with multiple zones: %+v", sc)
+	}
+}
+
+func TestReportUnavailableZones(t *testing.T) {
+	c := newTestController()
+	c.operatorClient = v1helpers.NewFakeOperatorClient(&operatorv1.OperatorSpec{}, &operatorv1.OperatorStatus{}, nil)
+	recorder := c.eventRecorder.(events.InMemoryRecorder)
+
+	for i := 0; i < 2; i++ {
+		if err := c.reportUnavailableZones(context.TODO(), []string{"gold (az3)"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	_, status, _, _ := c.operatorClient.GetOperatorState()
+	if cnd := v1helpers.FindOperatorCondition(status.Conditions, shareTypeZonesUnavailableCondition); cnd == nil || cnd.Status != operatorv1.ConditionTrue {
+		t.Errorf("expected %s condition, got %+v", shareTypeZonesUnavailableCondition, cnd)
+	}
+	// The same report is emitted only once.
+	if events := len(recorder.Events()); events != 1 {
+		t.Errorf("expected 1 event, got %d", events)
+	}
+
+	if err := c.reportUnavailableZones(context.TODO(), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, status, _, _ = c.operatorClient.GetOperatorState()
+	if cnd := v1helpers.FindOperatorCondition(status.Conditions, shareTypeZonesUnavailableCondition); cnd != nil {
+		t.Errorf("expected %s condition to be removed, got %+v", shareTypeZonesUnavailableCondition, cnd)
+	}
+}
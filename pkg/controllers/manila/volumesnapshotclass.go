@@ -0,0 +1,252 @@
+package manila
+
+import (
+	"context"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/csi-driver-manila-operator/assets"
+	"github.com/openshift/csi-driver-manila-operator/pkg/util"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceapply"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceread"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8serrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Template of generated VolumeSnapshotClasses.
+	volumeSnapshotClassAsset = "volumesnapshotclass.yaml"
+	// VolumeSnapshotClass installed for all share types by older versions
+	// of the operator.
+	legacyVolumeSnapshotClassName = "csi-manila-standard"
+	// Suffix of VolumeSnapshotClasses with Retain deletionPolicy.
+	retainVolumeSnapshotClassSuffix = "-retain"
+	// Label used by OADP / Velero to find VolumeSnapshotClasses of a CSI driver.
+	veleroVolumeSnapshotClassLabel = "velero.io/csi-volumesnapshot-class"
+)
+
+var volumeSnapshotClassGVR = schema.GroupVersionResource{
+	Group:    resourceapply.VolumeSnapshotClassGroup,
+	Version:  resourceapply.VolumeSnapshotClassVersion,
+	Resource: resourceapply.VolumeSnapshotClassResource,
+}
+
+// syncVolumeSnapshotClasses creates VolumeSnapshotClass for each generated
+// StorageClass whose share type supports snapshots. A VolumeSnapshotClass is
+// removed when its share type stops supporting snapshots or together with
+// its StorageClass. Like StorageClasses, they follow StorageClassState of the
+// ClusterCSIDriver.
+func (c *ManilaController) syncVolumeSnapshotClasses(ctx context.Context, expectedSCs []*storagev1.StorageClass, defaultShareTypeID string, cfg *operatorConfig) error {
+	if !c.volumeSnapshotCRDExists() {
+		klog.V(4).Infof("VolumeSnapshotClass CRD does not exist, skipping VolumeSnapshotClasses")
+		return nil
+	}
+	switch c.scStateEvaluator.GetStorageClassState(string(operatorv1.ManilaCSIDriver)) {
+	case operatorv1.UnmanagedStorageClass:
+		return nil
+	case operatorv1.RemovedStorageClass:
+		return c.removeVolumeSnapshotClasses(ctx)
+	}
+
+	expectedVSCs := generateVolumeSnapshotClasses(expectedSCs, defaultShareTypeID, cfg.RetainVolumeSnapshotClasses)
+	var errs []error
+	expectedNames := sets.New[string]()
+	for _, vsc := range expectedVSCs {
+		expectedNames.Insert(vsc.GetName())
+		if err := c.applyVolumeSnapshotClass(ctx, vsc); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := c.removeStaleVolumeSnapshotClasses(ctx, expectedNames, expectedSCs); err != nil {
+		errs = append(errs, err)
+	}
+	return k8serrors.NewAggregate(errs)
+}
+
+// generateVolumeSnapshotClasses returns VolumeSnapshotClasses for the
+// StorageClasses of share types with snapshot support. Velero uses the first
+// labeled class of a driver it finds, so only a single Delete policy class of
+// each driver gets the Velero label, see veleroShareTypes.
+func generateVolumeSnapshotClasses(expectedSCs []*storagev1.StorageClass, defaultShareTypeID string, withRetain bool) []*unstructured.Unstructured {
+	veleroShareTypeIDs := veleroShareTypes(expectedSCs, defaultShareTypeID)
+	var vscs []*unstructured.Unstructured
+	for _, sc := range expectedSCs {
+		if sc.Annotations[snapshotSupportAnnotation] != "true" {
+			continue
+		}
+		shareTypeID := sc.Labels[util.ShareTypeIDLabel]
+
+		vsc := readVolumeSnapshotClassTemplate()
+		vsc.SetName(sc.Name)
+		vsc.Object["driver"] = sc.Provisioner
+		labels := map[string]string{
+			util.ShareTypeIDLabel: shareTypeID,
+		}
+		// A share type has a single StorageClass of each driver.
+		if veleroShareTypeIDs[sc.Provisioner] == shareTypeID {
+			labels[veleroVolumeSnapshotClassLabel] = "true"
+		}
+		vsc.SetLabels(labels)
+		vsc.Object["deletionPolicy"] = "Delete"
+		vscs = append(vscs, vsc)
+
+		if !withRetain {
+			continue
+		}
+		retainName := sc.Name + retainVolumeSnapshotClassSuffix
+		if len(validation.IsDNS1123Subdomain(retainName)) > 0 {
+			klog.V(2).Infof("Skipping Retain VolumeSnapshotClass of StorageClass %s: name %s is not valid", sc.Name, retainName)
+			continue
+		}
+		retainVSC := readVolumeSnapshotClassTemplate()
+		retainVSC.SetName(retainName)
+		retainVSC.Object["driver"] = sc.Provisioner
+		retainVSC.SetLabels(map[string]string{
+			util.ShareTypeIDLabel: shareTypeID,
+		})
+		retainVSC.Object["deletionPolicy"] = "Retain"
+		vscs = append(vscs, retainVSC)
+	}
+	return vscs
+}
+
+// veleroShareTypes returns ID of the share type whose VolumeSnapshotClass gets
+// the Velero label, by driver. It's the default share type when it supports
+// snapshots, otherwise the first share type with snapshot support by name, so
+// the pick is stable across syncs.
+func veleroShareTypes(expectedSCs []*storagev1.StorageClass, defaultShareTypeID string) map[string]string {
+	picked := map[string]*storagev1.StorageClass{}
+	for _, sc := range expectedSCs {
+		if sc.Annotations[snapshotSupportAnnotation] != "true" {
+			continue
+		}
+		current, ok := picked[sc.Provisioner]
+		switch {
+		case !ok:
+		case current.Labels[util.ShareTypeIDLabel] == defaultShareTypeID:
+			continue
+		case sc.Labels[util.ShareTypeIDLabel] == defaultShareTypeID:
+		case lessByShareTypeName(sc, current):
+		default:
+			continue
+		}
+		picked[sc.Provisioner] = sc
+	}
+
+	ids := map[string]string{}
+	for driver, sc := range picked {
+		ids[driver] = sc.Labels[util.ShareTypeIDLabel]
+	}
+	return ids
+}
+
+// lessByShareTypeName orders StorageClasses by name and ID of their share
+// types.
+func lessByShareTypeName(a, b *storagev1.StorageClass) bool {
+	aName, bName := a.Annotations[shareTypeNameAnnotation], b.Annotations[shareTypeNameAnnotation]
+	if aName != bName {
+		return aName < bName
+	}
+	return a.Labels[util.ShareTypeIDLabel] < b.Labels[util.ShareTypeIDLabel]
+}
+
+func readVolumeSnapshotClassTemplate() *unstructured.Unstructured {
+	stream, err := assets.ReadFile(volumeSnapshotClassAsset)
+	if err != nil {
+		panic("Error loading the VolumeSnapshotClass resource")
+	}
+	return resourceread.ReadUnstructuredOrDie(stream)
+}
+
+// applyVolumeSnapshotClass applies the VolumeSnapshotClass, including its
+// labels. resourceapply.ApplyVolumeSnapshotClass does not update labels of
+// existing VolumeSnapshotClasses. The Velero label is removed when it's not
+// required, e.g. when the default share type changes.
+func (c *ManilaController) applyVolumeSnapshotClass(ctx context.Context, required *unstructured.Unstructured) error {
+	actual, _, err := resourceapply.ApplyVolumeSnapshotClass(ctx, c.dynamicClient, c.eventRecorder, required)
+	if err != nil {
+		return err
+	}
+
+	labels := actual.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	modified := false
+	for k, v := range required.GetLabels() {
+		if labels[k] != v {
+			labels[k] = v
+			modified = true
+		}
+	}
+	if _, ok := required.GetLabels()[veleroVolumeSnapshotClassLabel]; !ok {
+		if _, ok := labels[veleroVolumeSnapshotClassLabel]; ok {
+			delete(labels, veleroVolumeSnapshotClassLabel)
+			modified = true
+		}
+	}
+	if !modified {
+		return nil
+	}
+	actual = actual.DeepCopy()
+	actual.SetLabels(labels)
+	_, err = c.dynamicClient.Resource(volumeSnapshotClassGVR).Update(ctx, actual, metav1.UpdateOptions{})
+	return err
+}
+
+// removeStaleVolumeSnapshotClasses removes generated VolumeSnapshotClasses
+// that are not expected. A VolumeSnapshotClass of a share type that
+// disappeared from Manila is kept as long as its StorageClass exists, i.e.
+// during the StorageClass garbage collection grace period.
+func (c *ManilaController) removeStaleVolumeSnapshotClasses(ctx context.Context, expectedNames sets.Set[string], expectedSCs []*storagev1.StorageClass) error {
+	vscList, err := c.dynamicClient.Resource(volumeSnapshotClassGVR).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	expectedShareTypeIDs := sets.New[string]()
+	for _, sc := range expectedSCs {
+		expectedShareTypeIDs.Insert(sc.Labels[util.ShareTypeIDLabel])
+	}
+	generatedSCs, err := c.listGeneratedStorageClasses()
+	if err != nil {
+		return err
+	}
+	scShareTypeIDs := sets.New[string]()
+	for _, sc := range generatedSCs {
+		scShareTypeIDs.Insert(sc.Labels[util.ShareTypeIDLabel])
+	}
+
+	var errs []error
+	for i := range vscList.Items {
+		vsc := &vscList.Items[i]
+		driver, _, _ := unstructured.NestedString(vsc.Object, "driver")
+		if !isManilaDriver(driver) || expectedNames.Has(vsc.GetName()) {
+			continue
+		}
+
+		shareTypeID, generated := vsc.GetLabels()[util.ShareTypeIDLabel]
+		switch {
+		case !generated && vsc.GetName() == legacyVolumeSnapshotClassName:
+			klog.V(2).Infof("Removing VolumeSnapshotClass %s, it was replaced by VolumeSnapshotClasses of share types", vsc.GetName())
+		case !generated:
+			continue
+		case expectedShareTypeIDs.Has(shareTypeID) || !scShareTypeIDs.Has(shareTypeID):
+			klog.V(2).Infof("Removing VolumeSnapshotClass %s of share type %s", vsc.GetName(), shareTypeID)
+		default:
+			// Share type is missing, but its StorageClass has not been
+			// garbage collected yet.
+			continue
+		}
+		if _, _, err := resourceapply.DeleteVolumeSnapshotClass(ctx, c.dynamicClient, c.eventRecorder, vsc); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return k8serrors.NewAggregate(errs)
+}
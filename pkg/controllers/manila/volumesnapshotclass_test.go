@@ -0,0 +1,134 @@
+package manila
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/sharedfilesystems/v2/sharetypes"
+	"github.com/openshift/csi-driver-manila-operator/pkg/util"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+func TestGenerateVolumeSnapshotClasses(t *testing.T) {
+	c := &ManilaController{}
+	scs := []*storagev1.StorageClass{
+		c.generateStorageClass(sharetypes.ShareType{
+			ID:         "id1",
+			Name:       "gold",
+			ExtraSpecs: map[string]any{"snapshot_support": "True"},
+		}, shareProtocolNFS, "csi-manila-gold"),
+		c.generateStorageClass(sharetypes.ShareType{
+			ID:   "id2",
+			Name: "silver",
+		}, shareProtocolNFS, "csi-manila-silver"),
+		c.generateStorageClass(sharetypes.ShareType{
+			ID:         "id3",
+			Name:       "bronze",
+			ExtraSpecs: map[string]any{"snapshot_support": "True"},
+		}, shareProtocolNFS, "csi-manila-bronze"),
+	}
+
+	for _, tc := range []struct {
+		name               string
+		defaultShareTypeID string
+		withRetain         bool
+		expectedNames      []string
+		expectedPolicy     []string
+		expectedVelero     []bool
+	}{
+		{
+			name:               "without retain",
+			defaultShareTypeID: "id1",
+			expectedNames:      []string{"csi-manila-gold", "csi-manila-bronze"},
+			expectedPolicy:     []string{"Delete", "Delete"},
+			expectedVelero:     []bool{true, false},
+		},
+		{
+			name:               "with retain",
+			defaultShareTypeID: "id3",
+			withRetain:         true,
+			expectedNames:      []string{"csi-manila-gold", "csi-manila-gold-retain", "csi-manila-bronze", "csi-manila-bronze-retain"},
+			expectedPolicy:     []string{"Delete", "Retain", "Delete", "Retain"},
+			expectedVelero:     []bool{false, false, true, false},
+		},
+		{
+			// The first share type with snapshots by name is labeled.
+			name:           "no default share type",
+			expectedNames:  []string{"csi-manila-gold", "csi-manila-bronze"},
+			expectedPolicy: []string{"Delete", "Delete"},
+			expectedVelero: []bool{false, true},
+		},
+		{
+			name:               "default share type without snapshots",
+			defaultShareTypeID: "id2",
+			withRetain:         true,
+			expectedNames:      []string{"csi-manila-gold", "csi-manila-gold-retain", "csi-manila-bronze", "csi-manila-bronze-retain"},
+			expectedPolicy:     []string{"Delete", "Retain", "Delete", "Retain"},
+			expectedVelero:     []bool{false, false, true, false},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var names, policies []string
+			var velero []bool
+			for _, vsc := range generateVolumeSnapshotClasses(scs, tc.defaultShareTypeID, tc.withRetain) {
+				names = append(names, vsc.GetName())
+				policies = append(policies, vsc.Object["deletionPolicy"].(string))
+				_, hasLabel := vsc.GetLabels()[veleroVolumeSnapshotClassLabel]
+				velero = append(velero, hasLabel)
+				if id := vsc.GetLabels()["manila.csi.openstack.org/share-type-id"]; id != "id1" && id != "id3" {
+					t.Errorf("VolumeSnapshotClass %s has unexpected labels %v", vsc.GetName(), vsc.GetLabels())
+				}
+			}
+			if !reflect.DeepEqual(names, tc.expectedNames) {
+				t.Errorf("expected names %v, got %v", tc.expectedNames, names)
+			}
+			if !reflect.DeepEqual(policies, tc.expectedPolicy) {
+				t.Errorf("expected deletion policies %v, got %v", tc.expectedPolicy, policies)
+			}
+			if !reflect.DeepEqual(velero, tc.expectedVelero) {
+				t.Errorf("expected Velero labels %v, got %v", tc.expectedVelero, velero)
+			}
+		})
+	}
+}
+
+func TestVeleroShareTypes(t *testing.T) {
+	c := &ManilaController{}
+	snapshots := map[string]any{"snapshot_support": "True"}
+	scs := []*storagev1.StorageClass{
+		c.generateStorageClass(sharetypes.ShareType{ID: "id1", Name: "gold", ExtraSpecs: snapshots}, shareProtocolNFS, "csi-manila-gold"),
+		c.generateStorageClass(sharetypes.ShareType{ID: "id2", Name: "bronze", ExtraSpecs: snapshots}, shareProtocolNFS, "csi-manila-bronze"),
+		c.generateStorageClass(sharetypes.ShareType{ID: "id3", Name: "cephfs", ExtraSpecs: snapshots}, shareProtocolCephFS, "csi-manila-cephfs-cephfs"),
+		c.generateStorageClass(sharetypes.ShareType{ID: "id4", Name: "aaa"}, shareProtocolNFS, "csi-manila-aaa"),
+	}
+
+	for _, tc := range []struct {
+		name               string
+		defaultShareTypeID string
+		expected           map[string]string
+	}{
+		{
+			name:               "default share type",
+			defaultShareTypeID: "id1",
+			expected:           map[string]string{util.ManilaDriverName: "id1", util.ManilaCephFSDriverName: "id3"},
+		},
+		{
+			name:     "no default share type",
+			expected: map[string]string{util.ManilaDriverName: "id2", util.ManilaCephFSDriverName: "id3"},
+		},
+		{
+			name:               "default share type without snapshots",
+			defaultShareTypeID: "id4",
+			expected:           map[string]string{util.ManilaDriverName: "id2", util.ManilaCephFSDriverName: "id3"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The result does not depend on order of the share types.
+			for _, order := range [][]*storagev1.StorageClass{scs, {scs[3], scs[2], scs[1], scs[0]}} {
+				if got := veleroShareTypes(order, tc.defaultShareTypeID); !reflect.DeepEqual(got, tc.expected) {
+					t.Errorf("expected %v, got %v", tc.expected, got)
+				}
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package secret
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/gophercloud/utils/v2/openstack/clientconfig"
@@ -21,13 +23,25 @@ import (
 	"k8s.io/klog/v2"
 )
 
+// CredentialsValidator authenticates with clouds.yaml content read from
+// source. It returns true with the error when the credentials are rejected
+// and false with the error when they could not be validated, e.g. when
+// Keystone is unreachable.
+type CredentialsValidator func(cloudConfig []byte, source string) (bool, error)
+
 // This SecretSyncController translates Secret provided by cloud-credential-operator into
 // format required by the CSI driver.
 type SecretSyncController struct {
-	operatorClient v1helpers.OperatorClient
-	kubeClient     kubernetes.Interface
-	secretLister   corelisters.SecretLister
-	eventRecorder  events.Recorder
+	operatorClient      v1helpers.OperatorClient
+	kubeClient          kubernetes.Interface
+	secretLister        corelisters.SecretLister
+	validateCredentials CredentialsValidator
+	eventRecorder       events.Recorder
+	// Hashes of clouds.yaml and CA bundle that were last validated
+	// successfully and that were last rejected. Valid credentials are not
+	// validated again on each resync, rejected ones are reported only once.
+	validHash    string
+	rejectedHash string
 }
 
 const (
@@ -36,6 +50,13 @@ const (
 	// Name of OpenStack in clouds.yaml
 	// Canonical path for custom ca certificates
 	cacertPath = "/etc/kubernetes/static-pod-resources/configmaps/cloud-config/ca-bundle.pem"
+
+	// Condition reporting OpenStack credentials rejected by Keystone. The
+	// driver keeps using the last published credentials.
+	credentialsDegradedCondition = "SecretSyncCredentialsDegraded"
+	// Interval of validation retries of credentials that were rejected or
+	// could not be validated, Keystone may be unavailable only temporarily.
+	credentialsRetryInterval = 5 * time.Minute
 )
 
 func NewSecretSyncController(
@@ -43,15 +64,17 @@ func NewSecretSyncController(
 	kubeClient kubernetes.Interface,
 	informers v1helpers.KubeInformersForNamespaces,
 	resync time.Duration,
+	validateCredentials CredentialsValidator,
 	eventRecorder events.Recorder) factory.Controller {
 
 	// Read secret from operator namespace and save the translated one to the operand namespace
 	secretInformer := informers.InformersFor(util.OperatorNamespace)
 	c := &SecretSyncController{
-		operatorClient: operatorClient,
-		kubeClient:     kubeClient,
-		secretLister:   secretInformer.Core().V1().Secrets().Lister(),
-		eventRecorder:  eventRecorder.WithComponentSuffix("SecretSync"),
+		operatorClient:      operatorClient,
+		kubeClient:          kubeClient,
+		secretLister:        secretInformer.Core().V1().Secrets().Lister(),
+		validateCredentials: validateCredentials,
+		eventRecorder:       eventRecorder.WithComponentSuffix("SecretSync"),
 	}
 	return factory.New().WithSync(c.sync).ResyncEvery(resync).WithSyncDegradedOnError(operatorClient).WithInformers(
 		operatorClient.Informer(),
@@ -83,6 +106,22 @@ func (c *SecretSyncController) sync(ctx context.Context, syncCtx factory.SyncCon
 		return err
 	}
 
+	caBundle, err := os.ReadFile(util.CertFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read CA bundle %s: %w", util.CertFile, err)
+	}
+	publish, err := c.checkCredentials(ctx, cloudSecret.Data[cloudSecretKey], caBundle)
+	if err != nil {
+		return err
+	}
+	if c.validHash == "" {
+		syncCtx.Queue().AddAfter(syncCtx.QueueKey(), credentialsRetryInterval)
+	}
+	if !publish {
+		// Keep the last published driver secret.
+		return nil
+	}
+
 	_, _, err = resourceapply.ApplySecret(ctx, c.kubeClient.CoreV1(), c.eventRecorder, driverSecret)
 	if err != nil {
 		return err
@@ -90,6 +129,62 @@ func (c *SecretSyncController) sync(ctx context.Context, syncCtx factory.SyncCon
 	return nil
 }
 
+// checkCredentials authenticates with clouds.yaml content and CA bundle and
+// reports the result in credentialsDegradedCondition. It returns false when
+// the credentials must not be published, i.e. when Keystone rejects them.
+// Credentials that could not be validated are published, unless they were
+// rejected before.
+func (c *SecretSyncController) checkCredentials(ctx context.Context, cloudConfig, caBundle []byte) (bool, error) {
+	h := sha256.New()
+	h.Write(cloudConfig)
+	h.Write(caBundle)
+	hash := fmt.Sprintf("%x", h.Sum(nil))
+	if hash == c.validHash {
+		return true, nil
+	}
+
+	source := fmt.Sprintf("secret %s/%s", util.OperatorNamespace, util.CloudCredentialSecretName)
+	rejected, err := c.validateCredentials(cloudConfig, source)
+	if err != nil && !rejected {
+		klog.Warningf("Unable to validate OpenStack credentials from %s: %v", source, err)
+		c.validHash = ""
+		if hash == c.rejectedHash {
+			// Still report the last rejection.
+			return false, nil
+		}
+		return true, c.setCredentialsCondition(ctx, operatorv1.ConditionFalse, "ValidationFailed",
+			fmt.Sprintf("Unable to validate OpenStack credentials from %s, the driver uses them anyway: %v", source, err))
+	}
+	if err != nil {
+		klog.Warningf("OpenStack credentials from %s were rejected, keeping the current driver credentials: %v", source, err)
+		if hash != c.rejectedHash {
+			c.eventRecorder.Warningf("InvalidOpenStackCredentials", "OpenStack credentials from %s were rejected, keeping the current driver credentials: %v", source, err)
+			c.rejectedHash = hash
+		}
+		// The condition is cleared only after the next successful validation.
+		c.validHash = ""
+		return false, c.setCredentialsCondition(ctx, operatorv1.ConditionTrue, "InvalidCredentials",
+			fmt.Sprintf("OpenStack credentials from %s were rejected, the driver uses the last valid ones: %v", source, err))
+	}
+
+	if err := c.setCredentialsCondition(ctx, operatorv1.ConditionFalse, "AsExpected", ""); err != nil {
+		return false, err
+	}
+	c.validHash = hash
+	c.rejectedHash = ""
+	return true, nil
+}
+
+func (c *SecretSyncController) setCredentialsCondition(ctx context.Context, status operatorv1.ConditionStatus, reason, msg string) error {
+	_, _, err := v1helpers.UpdateStatus(ctx, c.operatorClient, v1helpers.UpdateConditionFn(operatorv1.OperatorCondition{
+		Type:    credentialsDegradedCondition,
+		Status:  status,
+		Reason:  reason,
+		Message: msg,
+	}))
+	return err
+}
+
 func (c *SecretSyncController) translateSecret(cloudSecret *v1.Secret) (*v1.Secret, error) {
 	content, ok := cloudSecret.Data[cloudSecretKey]
 	if !ok {
@@ -176,3 +271,30 @@ func cloudToConf(cloud clientconfig.Cloud) map[string][]byte {
 
 	return data
 }
+
+// ConfToCloud returns cloud with the credentials of the driver Secret data
+// created by cloudToConf, i.e. the credentials the driver uses.
+func ConfToCloud(data map[string][]byte) clientconfig.Cloud {
+	auth := &clientconfig.AuthInfo{
+		AuthURL:                     string(data["os-authURL"]),
+		UserID:                      string(data["os-userID"]),
+		Username:                    string(data["os-userName"]),
+		Password:                    string(data["os-password"]),
+		ApplicationCredentialID:     string(data["os-applicationCredentialID"]),
+		ApplicationCredentialName:   string(data["os-applicationCredentialName"]),
+		ApplicationCredentialSecret: string(data["os-applicationCredentialSecret"]),
+		ProjectID:                   string(data["os-projectID"]),
+		ProjectName:                 string(data["os-projectName"]),
+		DomainID:                    string(data["os-domainID"]),
+		DomainName:                  string(data["os-domainName"]),
+		ProjectDomainID:             string(data["os-projectDomainID"]),
+		ProjectDomainName:           string(data["os-projectDomainName"]),
+		UserDomainID:                string(data["os-userDomainID"]),
+		UserDomainName:              string(data["os-userDomainName"]),
+	}
+	return clientconfig.Cloud{
+		AuthInfo:   auth,
+		RegionName: string(data["os-region"]),
+		CACertFile: string(data["os-certAuthorityPath"]),
+	}
+}
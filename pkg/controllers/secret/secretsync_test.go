@@ -2,9 +2,15 @@ package secret
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"reflect"
 	"testing"
 
 	"github.com/gophercloud/utils/v2/openstack/clientconfig"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -85,6 +91,82 @@ clouds:
 					t.Errorf("expected key %q, not found", wantK)
 				}
 			}
+
+			// The driver Secret translates back to the same credentials.
+			if roundTrip := cloudToConf(ConfToCloud(have)); !reflect.DeepEqual(roundTrip, have) {
+				t.Errorf("expected %q after round trip, got %q", have, roundTrip)
+			}
 		})
 	}
 }
+
+func TestCheckCredentials(t *testing.T) {
+	operatorClient := v1helpers.NewFakeOperatorClient(&operatorv1.OperatorSpec{}, &operatorv1.OperatorStatus{}, nil)
+	recorder := events.NewInMemoryRecorder("test")
+	validations := 0
+	keystoneDown := false
+	c := &SecretSyncController{
+		operatorClient: operatorClient,
+		eventRecorder:  recorder,
+		validateCredentials: func(cloudConfig []byte, source string) (bool, error) {
+			validations++
+			if keystoneDown {
+				return false, errors.New("connection refused")
+			}
+			if string(cloudConfig) == "bad" {
+				return true, errors.New("The request you have made requires authentication")
+			}
+			return false, nil
+		},
+	}
+	check := func(cloudConfig, caBundle string, expectPublish bool, expectStatus operatorv1.ConditionStatus, expectReason string) {
+		t.Helper()
+		publish, err := c.checkCredentials(context.TODO(), []byte(cloudConfig), []byte(caBundle))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if publish != expectPublish {
+			t.Errorf("expected publish %t for %q, got %t", expectPublish, cloudConfig, publish)
+		}
+		_, status, _, _ := operatorClient.GetOperatorState()
+		cnd := v1helpers.FindOperatorCondition(status.Conditions, credentialsDegradedCondition)
+		if cnd == nil || cnd.Status != expectStatus || cnd.Reason != expectReason {
+			t.Errorf("expected %s condition %s (%s), got %+v", credentialsDegradedCondition, expectStatus, expectReason, cnd)
+		}
+	}
+
+	check("good", "", true, operatorv1.ConditionFalse, "AsExpected")
+	// Valid credentials are not validated again.
+	check("good", "", true, operatorv1.ConditionFalse, "AsExpected")
+	if validations != 1 {
+		t.Errorf("expected 1 validation, got %d", validations)
+	}
+	// A new CA bundle is validated.
+	check("good", "ca", true, operatorv1.ConditionFalse, "AsExpected")
+	if validations != 2 {
+		t.Errorf("expected 2 validations, got %d", validations)
+	}
+
+	check("bad", "ca", false, operatorv1.ConditionTrue, "InvalidCredentials")
+	// Rejected credentials are validated again, but reported only once.
+	check("bad", "ca", false, operatorv1.ConditionTrue, "InvalidCredentials")
+	if validations != 4 {
+		t.Errorf("expected 4 validations, got %d", validations)
+	}
+	if events := len(recorder.Events()); events != 1 {
+		t.Errorf("expected 1 event, got %d", events)
+	}
+
+	// Rejected credentials stay rejected while Keystone is down.
+	keystoneDown = true
+	check("bad", "ca", false, operatorv1.ConditionTrue, "InvalidCredentials")
+	// Credentials that can't be validated are published and validated again.
+	check("good", "ca", true, operatorv1.ConditionFalse, "ValidationFailed")
+	check("good", "ca", true, operatorv1.ConditionFalse, "ValidationFailed")
+	if validations != 7 {
+		t.Errorf("expected 7 validations, got %d", validations)
+	}
+
+	keystoneDown = false
+	check("good", "ca", true, operatorv1.ConditionFalse, "AsExpected")
+}
@@ -10,7 +10,9 @@ import (
 	"github.com/openshift/csi-driver-manila-operator/pkg/controllers/manila"
 	"github.com/openshift/csi-driver-manila-operator/pkg/controllers/secret"
 	"github.com/openshift/csi-driver-manila-operator/pkg/util"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceapply"
 	"github.com/openshift/library-go/pkg/operator/resourcesynccontroller"
+	"github.com/openshift/library-go/pkg/operator/staticresourcecontroller"
 	apiextclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/dynamic"
@@ -21,6 +23,8 @@ import (
 	operatorapi "github.com/openshift/api/operator/v1"
 	configclient "github.com/openshift/client-go/config/clientset/versioned"
 	configinformers "github.com/openshift/client-go/config/informers/externalversions"
+	opclient "github.com/openshift/client-go/operator/clientset/versioned"
+	opinformers "github.com/openshift/client-go/operator/informers/externalversions"
 	"github.com/openshift/library-go/pkg/controller/controllercmd"
 	"github.com/openshift/library-go/pkg/controller/factory"
 	csicontrollerset "github.com/openshift/library-go/pkg/operator/csi/csicontrollerset"
@@ -37,14 +41,15 @@ const (
 	metricsCertSecretName = "manila-csi-driver-controller-metrics-serving-cert"
 	trustedCAConfigMap    = "manila-csi-driver-trusted-ca-bundle"
 
-	nfsImageEnvName = "NFS_DRIVER_IMAGE"
+	nfsImageEnvName    = "NFS_DRIVER_IMAGE"
+	cephfsImageEnvName = "CEPHFS_DRIVER_IMAGE"
 
 	resync = 20 * time.Minute
 )
 
 func RunOperator(ctx context.Context, controllerConfig *controllercmd.ControllerContext) error {
 	kubeClient := kubeclient.NewForConfigOrDie(rest.AddUserAgent(controllerConfig.KubeConfig, operatorName))
-	kubeInformersForNamespaces := v1helpers.NewKubeInformersForNamespaces(kubeClient, util.OperatorNamespace, util.OperandNamespace, util.CloudConfigNamespace, "")
+	kubeInformersForNamespaces := v1helpers.NewKubeInformersForNamespaces(kubeClient, util.OperatorNamespace, util.OperandNamespace, util.CloudConfigNamespace, util.InstallConfigNamespace, "")
 	secretInformer := kubeInformersForNamespaces.InformersFor(util.OperandNamespace).Core().V1().Secrets()
 	configMapInformer := kubeInformersForNamespaces.InformersFor(util.OperandNamespace).Core().V1().ConfigMaps()
 	nodeInformer := kubeInformersForNamespaces.InformersFor("").Core().V1().Nodes()
@@ -58,6 +63,10 @@ func RunOperator(ctx context.Context, controllerConfig *controllercmd.Controller
 	configClient := configclient.NewForConfigOrDie(rest.AddUserAgent(controllerConfig.KubeConfig, operatorName))
 	configInformers := configinformers.NewSharedInformerFactory(configClient, resync)
 
+	// ClusterCSIDriver informer is used to read StorageClassState.
+	operatorClientSet := opclient.NewForConfigOrDie(rest.AddUserAgent(controllerConfig.KubeConfig, operatorName))
+	operatorInformers := opinformers.NewSharedInformerFactory(operatorClientSet, resync)
+
 	// Create GenericOperatorclient. This is used by controllers created down below
 	gvr := operatorapi.SchemeGroupVersion.WithResource("clustercsidrivers")
 	operatorClient, dynamicInformers, err := goc.NewClusterScopedOperatorClientWithConfigName(controllerConfig.KubeConfig, gvr, string(operatorapi.ManilaCSIDriver))
@@ -70,169 +79,298 @@ func RunOperator(ctx context.Context, controllerConfig *controllercmd.Controller
 		return err
 	}
 
-	csiDriverControllerSet := csicontrollerset.NewCSIControllerSet(
-		operatorClient,
-		controllerConfig.EventRecorder,
-	).WithLogLevelController().WithManagementStateController(
-		operandName,
-		false,
-	).WithStaticResourcesController(
-		"ManilaDriverStaticResources",
-		kubeClient,
-		dynamicClient,
-		kubeInformersForNamespaces,
-		assets.ReadFile,
-		[]string{
-			// Create RBAC before creating Service Accounts.
-			// This prevents a race where the controller/node can
-			// try to create pods before the RBAC has been loaded,
-			// leading to an initial admission failure. We avoid
-			// this by exploiting the fact that the pods cannot be
-			// scheduled until the SA has been created.
-			"rbac/main_snapshotter_binding.yaml",
-			"rbac/main_provisioner_binding.yaml",
-			"rbac/volumeattachment_reader_provisioner_binding.yaml",
-			"rbac/privileged_role.yaml",
-			"rbac/controller_privileged_binding.yaml",
-			"rbac/node_privileged_binding.yaml",
-			"rbac/kube_rbac_proxy_role.yaml",
-			"rbac/kube_rbac_proxy_binding.yaml",
-			"rbac/prometheus_role.yaml",
-			"rbac/prometheus_rolebinding.yaml",
-			"rbac/lease_leader_election_role.yaml",
-			"rbac/lease_leader_election_rolebinding.yaml",
-			"controller_sa.yaml",
-			"controller_pdb.yaml",
-			"node_sa.yaml",
-			"service.yaml",
-			"cabundle_cm.yaml",
-		},
-	).WithConditionalStaticResourcesController(
-		"ManilaDriverConditionalStaticResourcesController",
-		kubeClient,
-		dynamicClient,
-		kubeInformersForNamespaces,
-		assets.ReadFile,
-		[]string{
-			"volumesnapshotclass.yaml",
-		},
-		// Only install when CRD exists.
-		func() bool {
-			name := "volumesnapshotclasses.snapshot.storage.k8s.io"
-			_, err := apiExtClient.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), name, metav1.GetOptions{})
-			return err == nil
-		},
-		// Don't ever remove.
-		func() bool {
-			return false
-		},
-	).WithCSIConfigObserverController(
-		"ManilaDriverCSIConfigObserverController",
-		configInformers,
-	).WithCSIDriverControllerService(
-		"ManilaDriverControllerServiceController",
-		assetWithNFSDriver,
-		"controller.yaml",
-		kubeClient,
-		kubeInformersForNamespaces.InformersFor(util.OperandNamespace),
-		configInformers,
-		[]factory.Informer{
-			nodeInformer.Informer(),
-			secretInformer.Informer(),
-			configMapInformer.Informer()},
-		csidrivercontrollerservicecontroller.WithObservedProxyDeploymentHook(),
-		csidrivercontrollerservicecontroller.WithCABundleDeploymentHook(
-			util.OperandNamespace,
-			trustedCAConfigMap,
-			configMapInformer,
-		),
-		csidrivercontrollerservicecontroller.WithSecretHashAnnotationHook(
-			util.OperandNamespace,
-			metricsCertSecretName,
-			secretInformer,
-		),
-		csidrivercontrollerservicecontroller.WithReplicasHook(nodeInformer.Lister()),
-	).WithCSIDriverNodeService(
-		"ManilaDriverNodeServiceController",
-		assetWithNFSDriver,
-		"node.yaml",
-		kubeClient,
-		kubeInformersForNamespaces.InformersFor(util.OperandNamespace),
-		nil,
-		csidrivernodeservicecontroller.WithObservedProxyDaemonSetHook(),
-		csidrivernodeservicecontroller.WithCABundleDaemonSetHook(
-			util.OperandNamespace,
-			trustedCAConfigMap,
-			configMapInformer,
-		),
-	).WithServiceMonitorController(
-		"ManilaDriverServiceMonitorController",
-		dynamicClient,
-		assets.ReadFile,
-		"servicemonitor.yaml",
-	)
-
-	dsBytes, err := assetWithNFSDriver("node_nfs.yaml")
-	if err != nil {
-		return err
+	// VolumeSnapshotClasses are installed only when CRD exists.
+	volumeSnapshotCRDExists := func() bool {
+		name := "volumesnapshotclasses.snapshot.storage.k8s.io"
+		_, err := apiExtClient.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), name, metav1.GetOptions{})
+		return err == nil
 	}
-	nfsCSIDriverController := csidrivernodeservicecontroller.NewCSIDriverNodeServiceController(
-		"NFSDriverNodeServiceController",
-		dsBytes,
-		controllerConfig.EventRecorder,
-		operatorClient,
-		kubeClient,
-		kubeInformersForNamespaces.InformersFor(util.OperandNamespace).Apps().V1().DaemonSets(),
-		[]factory.Informer{configMapInformer.Informer()},
-	)
 
-	// sync config map with OpenStack CA certificate to the operand namespace,
-	// so the driver can get it as a ConfigMap volume.
-	srcConfigMap := resourcesynccontroller.ResourceLocation{
-		Namespace: util.CloudConfigNamespace,
-		Name:      util.CloudConfigName,
+	// Informer factories start only informers requested before Start is
+	// called, so they're started again after new controllers are created.
+	startInformers := func() {
+		kubeInformersForNamespaces.Start(ctx.Done())
+		dynamicInformers.Start(ctx.Done())
+		configInformers.Start(ctx.Done())
+		operatorInformers.Start(ctx.Done())
 	}
-	dstConfigMap := resourcesynccontroller.ResourceLocation{
-		Namespace: util.OperandNamespace,
-		Name:      util.CloudConfigName,
+
+	// The driver controllers are started by ManilaController when it detects
+	// Manila and stopped when the operator is Removed or Manila is gone. Each
+	// start needs new controller instances.
+	newCSIControllers := func() ([]manila.Runnable, error) {
+		csiDriverControllerSet := csicontrollerset.NewCSIControllerSet(
+			operatorClient,
+			controllerConfig.EventRecorder,
+		).WithLogLevelController().WithManagementStateController(
+			operandName,
+			true,
+		).WithStaticResourcesController(
+			"ManilaDriverStaticResources",
+			kubeClient,
+			dynamicClient,
+			kubeInformersForNamespaces,
+			assets.ReadFile,
+			[]string{
+				// Create RBAC before creating Service Accounts.
+				// This prevents a race where the controller/node can
+				// try to create pods before the RBAC has been loaded,
+				// leading to an initial admission failure. We avoid
+				// this by exploiting the fact that the pods cannot be
+				// scheduled until the SA has been created.
+				"rbac/main_snapshotter_binding.yaml",
+				"rbac/main_resizer_binding.yaml",
+				"rbac/main_provisioner_binding.yaml",
+				"rbac/volumeattachment_reader_provisioner_binding.yaml",
+				"rbac/privileged_role.yaml",
+				"rbac/controller_privileged_binding.yaml",
+				"rbac/node_privileged_binding.yaml",
+				"rbac/kube_rbac_proxy_role.yaml",
+				"rbac/kube_rbac_proxy_binding.yaml",
+				"rbac/prometheus_role.yaml",
+				"rbac/prometheus_rolebinding.yaml",
+				"rbac/lease_leader_election_role.yaml",
+				"rbac/lease_leader_election_rolebinding.yaml",
+				"controller_sa.yaml",
+				"controller_pdb.yaml",
+				"node_sa.yaml",
+				"service.yaml",
+				"cabundle_cm.yaml",
+			},
+		).WithCSIConfigObserverController(
+			"ManilaDriverCSIConfigObserverController",
+			configInformers,
+		).WithCSIDriverControllerService(
+			"ManilaDriverControllerServiceController",
+			assetWithFwdDrivers,
+			"controller.yaml",
+			kubeClient,
+			kubeInformersForNamespaces.InformersFor(util.OperandNamespace),
+			configInformers,
+			[]factory.Informer{
+				nodeInformer.Informer(),
+				secretInformer.Informer(),
+				configMapInformer.Informer()},
+			csidrivercontrollerservicecontroller.WithObservedProxyDeploymentHook(),
+			csidrivercontrollerservicecontroller.WithCABundleDeploymentHook(
+				util.OperandNamespace,
+				trustedCAConfigMap,
+				configMapInformer,
+			),
+			csidrivercontrollerservicecontroller.WithSecretHashAnnotationHook(
+				util.OperandNamespace,
+				metricsCertSecretName,
+				secretInformer,
+			),
+			// Roll out the driver when OpenStack credentials are rotated.
+			csidrivercontrollerservicecontroller.WithSecretHashAnnotationHook(
+				util.OperandNamespace,
+				util.ManilaSecretName,
+				secretInformer,
+			),
+			csidrivercontrollerservicecontroller.WithReplicasHook(nodeInformer.Lister()),
+		).WithCSIDriverNodeService(
+			"ManilaDriverNodeServiceController",
+			assetWithFwdDrivers,
+			"node.yaml",
+			kubeClient,
+			kubeInformersForNamespaces.InformersFor(util.OperandNamespace),
+			[]factory.Informer{secretInformer.Informer()},
+			csidrivernodeservicecontroller.WithObservedProxyDaemonSetHook(),
+			csidrivernodeservicecontroller.WithCABundleDaemonSetHook(
+				util.OperandNamespace,
+				trustedCAConfigMap,
+				configMapInformer,
+			),
+			csidrivernodeservicecontroller.WithSecretHashAnnotationHook(
+				util.OperandNamespace,
+				util.ManilaSecretName,
+				secretInformer,
+			),
+		).WithServiceMonitorController(
+			"ManilaDriverServiceMonitorController",
+			dynamicClient,
+			assets.ReadFile,
+			"servicemonitor.yaml",
+		)
+
+		dsBytes, err := assetWithFwdDrivers("node_nfs.yaml")
+		if err != nil {
+			return nil, err
+		}
+		nfsCSIDriverController := csidrivernodeservicecontroller.NewCSIDriverNodeServiceController(
+			"NFSDriverNodeServiceController",
+			dsBytes,
+			controllerConfig.EventRecorder,
+			operatorClient,
+			kubeClient,
+			kubeInformersForNamespaces.InformersFor(util.OperandNamespace).Apps().V1().DaemonSets(),
+			[]factory.Informer{configMapInformer.Informer()},
+		)
+
+		// sync config map with OpenStack CA certificate to the operand namespace,
+		// so the driver can get it as a ConfigMap volume.
+		srcConfigMap := resourcesynccontroller.ResourceLocation{
+			Namespace: util.CloudConfigNamespace,
+			Name:      util.CloudConfigName,
+		}
+		dstConfigMap := resourcesynccontroller.ResourceLocation{
+			Namespace: util.OperandNamespace,
+			Name:      util.CloudConfigName,
+		}
+		certController := resourcesynccontroller.NewResourceSyncController(
+			operatorClient,
+			kubeInformersForNamespaces,
+			kubeClient.CoreV1(),
+			kubeClient.CoreV1(),
+			controllerConfig.EventRecorder)
+		if err := certController.SyncConfigMap(dstConfigMap, srcConfigMap); err != nil {
+			return nil, err
+		}
+
+		secretSyncController := secret.NewSecretSyncController(
+			operatorClient,
+			kubeClient,
+			kubeInformersForNamespaces,
+			resync,
+			manila.ValidateCredentials,
+			controllerConfig.EventRecorder)
+
+		startInformers()
+		return []manila.Runnable{
+			csiDriverControllerSet,
+			nfsCSIDriverController,
+			secretSyncController,
+			certController,
+		}, nil
 	}
-	certController := resourcesynccontroller.NewResourceSyncController(
-		operatorClient,
-		kubeInformersForNamespaces,
-		kubeClient.CoreV1(),
-		kubeClient.CoreV1(),
-		controllerConfig.EventRecorder)
-	if err := certController.SyncConfigMap(dstConfigMap, srcConfigMap); err != nil {
-		return err
+
+	// CephFS instance of the driver is started only when Manila offers CephFS.
+	newCephFSControllers := func() ([]manila.Runnable, error) {
+		cephfsStaticResourcesController := staticresourcecontroller.NewStaticResourceController(
+			"ManilaCephFSDriverStaticResources",
+			assets.ReadFile,
+			[]string{
+				"cephfs/controller_pdb.yaml",
+			},
+			(&resourceapply.ClientHolder{}).WithKubernetes(kubeClient),
+			operatorClient,
+			controllerConfig.EventRecorder,
+		).AddKubeInformers(kubeInformersForNamespaces)
+
+		cephfsControllerBytes, err := assetWithFwdDrivers("cephfs/controller.yaml")
+		if err != nil {
+			return nil, err
+		}
+		cephfsControllerServiceController := csidrivercontrollerservicecontroller.NewCSIDriverControllerServiceController(
+			"ManilaCephFSDriverControllerServiceController",
+			cephfsControllerBytes,
+			controllerConfig.EventRecorder,
+			operatorClient,
+			kubeClient,
+			kubeInformersForNamespaces.InformersFor(util.OperandNamespace).Apps().V1().Deployments(),
+			configInformers,
+			[]factory.Informer{
+				nodeInformer.Informer(),
+				secretInformer.Informer(),
+				configMapInformer.Informer()},
+			csidrivercontrollerservicecontroller.WithObservedProxyDeploymentHook(),
+			csidrivercontrollerservicecontroller.WithCABundleDeploymentHook(
+				util.OperandNamespace,
+				trustedCAConfigMap,
+				configMapInformer,
+			),
+			csidrivercontrollerservicecontroller.WithSecretHashAnnotationHook(
+				util.OperandNamespace,
+				util.ManilaSecretName,
+				secretInformer,
+			),
+			csidrivercontrollerservicecontroller.WithReplicasHook(nodeInformer.Lister()),
+		)
+		cephfsNodeBytes, err := assetWithFwdDrivers("cephfs/node.yaml")
+		if err != nil {
+			return nil, err
+		}
+		cephfsNodeServiceController := csidrivernodeservicecontroller.NewCSIDriverNodeServiceController(
+			"ManilaCephFSDriverNodeServiceController",
+			cephfsNodeBytes,
+			controllerConfig.EventRecorder,
+			operatorClient,
+			kubeClient,
+			kubeInformersForNamespaces.InformersFor(util.OperandNamespace).Apps().V1().DaemonSets(),
+			[]factory.Informer{configMapInformer.Informer(), secretInformer.Informer()},
+			csidrivernodeservicecontroller.WithObservedProxyDaemonSetHook(),
+			csidrivernodeservicecontroller.WithCABundleDaemonSetHook(
+				util.OperandNamespace,
+				trustedCAConfigMap,
+				configMapInformer,
+			),
+			csidrivernodeservicecontroller.WithSecretHashAnnotationHook(
+				util.OperandNamespace,
+				util.ManilaSecretName,
+				secretInformer,
+			),
+		)
+		cephfsDSBytes, err := assetWithFwdDrivers("cephfs/node_cephfs.yaml")
+		if err != nil {
+			return nil, err
+		}
+		cephfsCSIDriverController := csidrivernodeservicecontroller.NewCSIDriverNodeServiceController(
+			"CephFSDriverNodeServiceController",
+			cephfsDSBytes,
+			controllerConfig.EventRecorder,
+			operatorClient,
+			kubeClient,
+			kubeInformersForNamespaces.InformersFor(util.OperandNamespace).Apps().V1().DaemonSets(),
+			[]factory.Informer{configMapInformer.Informer()},
+		)
+
+		startInformers()
+		return []manila.Runnable{
+			cephfsStaticResourcesController,
+			cephfsControllerServiceController,
+			cephfsNodeServiceController,
+			cephfsCSIDriverController,
+		}, nil
 	}
 
-	secretSyncController := secret.NewSecretSyncController(
+	// Metrics and alerts of the operator itself are installed regardless of
+	// Manila presence.
+	manila.RegisterConditionMetrics(operatorClient)
+	operatorMonitoringController := staticresourcecontroller.NewStaticResourceController(
+		"ManilaOperatorMonitoringStaticResources",
+		assets.ReadFile,
+		[]string{
+			"operator/prometheus_role.yaml",
+			"operator/prometheus_rolebinding.yaml",
+			"operator/prometheusrule.yaml",
+			"operator/service.yaml",
+			"operator/servicemonitor.yaml",
+		},
+		(&resourceapply.ClientHolder{}).WithKubernetes(kubeClient).WithDynamicClient(dynamicClient),
 		operatorClient,
-		kubeClient,
-		kubeInformersForNamespaces,
-		resync,
-		controllerConfig.EventRecorder)
+		controllerConfig.EventRecorder,
+	).AddKubeInformers(kubeInformersForNamespaces).WithIgnoreNotFoundOnCreate()
 
 	manilaController := manila.NewManilaController(
 		operatorClient,
 		kubeClient,
+		dynamicClient,
 		kubeInformersForNamespaces,
-		[]manila.Runnable{
-			csiDriverControllerSet,
-			nfsCSIDriverController,
-			secretSyncController,
-			certController,
-		},
+		configInformers,
+		operatorInformers,
+		volumeSnapshotCRDExists,
+		newCSIControllers,
+		newCephFSControllers,
 		controllerConfig.EventRecorder,
 	)
 
 	klog.Info("Starting the informers")
-	go kubeInformersForNamespaces.Start(ctx.Done())
-	go dynamicInformers.Start(ctx.Done())
-	go configInformers.Start(ctx.Done())
+	startInformers()
 
 	klog.Info("Starting controllers")
 	go manilaController.Run(ctx, 1)
+	go operatorMonitoringController.Run(ctx, 1)
 
 	<-ctx.Done()
 
@@ -240,17 +378,19 @@ func RunOperator(ctx context.Context, controllerConfig *controllercmd.Controller
 }
 
 // CSIDriverController can replace only a single driver in driver manifests.
-// Manila needs to replace two of them: Manila driver and NFS driver image.
-// Let the Manila image be replaced by CSIDriverController and NFS in this
-// custom asset loading func.
-func assetWithNFSDriver(file string) ([]byte, error) {
+// Manila needs to replace three of them: Manila driver and NFS and CephFS
+// driver images. Let the Manila image be replaced by CSIDriverController and
+// the others in this custom asset loading func.
+func assetWithFwdDrivers(file string) ([]byte, error) {
 	asset, err := assets.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
-	nfsImage := os.Getenv(nfsImageEnvName)
-	if nfsImage == "" {
-		return asset, nil
+	if nfsImage := os.Getenv(nfsImageEnvName); nfsImage != "" {
+		asset = bytes.ReplaceAll(asset, []byte("${NFS_DRIVER_IMAGE}"), []byte(nfsImage))
+	}
+	if cephfsImage := os.Getenv(cephfsImageEnvName); cephfsImage != "" {
+		asset = bytes.ReplaceAll(asset, []byte("${CEPHFS_DRIVER_IMAGE}"), []byte(cephfsImage))
 	}
-	return bytes.ReplaceAll(asset, []byte("${NFS_DRIVER_IMAGE}"), []byte(nfsImage)), nil
+	return asset, nil
 }
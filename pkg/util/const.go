@@ -9,8 +9,27 @@ const (
 	CloudConfigNamespace = "openshift-config"
 	CloudConfigName      = "cloud-provider-config"
 
+	// ConfigMap with install-config.yaml of the cluster.
+	InstallConfigNamespace = "kube-system"
+	InstallConfigName      = "cluster-config-v1"
+
 	StorageClassNamePrefix = "csi-manila-"
 
+	// Name of the CSI driver, also used as the ClusterCSIDriver name.
+	ManilaDriverName = "manila.csi.openstack.org"
+	// Name of the CSI driver instance that provisions CephFS shares.
+	ManilaCephFSDriverName = "cephfs.manila.csi.openstack.org"
+
+	// Label with ID of the Manila share type a StorageClass was generated for.
+	// Only StorageClasses with this label are managed by the operator.
+	ShareTypeIDLabel = "manila.csi.openstack.org/share-type-id"
+	// Label with share protocol of a generated StorageClass. StorageClasses
+	// without the label are NFS ones.
+	ShareProtocolLabel = "manila.csi.openstack.org/share-protocol"
+	// Annotation with time (RFC 3339) when the share type of a generated
+	// StorageClass was first found missing in Manila.
+	ShareTypeMissingSinceAnnotation = "manila.csi.openstack.org/share-type-missing-since"
+
 	// OpenStack config file name (as present in the operator Deployment)
 	CloudConfigFilename = "/etc/openstack/clouds.yaml"
 	CertFile            = "/etc/openstack-ca/ca-bundle.pem"